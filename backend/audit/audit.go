@@ -0,0 +1,171 @@
+// Package audit records state-changing actions in the audit log. Entries
+// are queued and written in batches by a background worker, so recording
+// one costs a request next to nothing.
+package audit
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gaming-panel/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	queueSize     = 1024
+	batchSize     = 100
+	flushInterval = time.Second
+	// enqueueTimeout is how long Log waits for room in a full queue
+	// before dropping the entry.
+	enqueueTimeout = 100 * time.Millisecond
+)
+
+// Logger queues audit log entries for a background writer.
+type Logger struct {
+	db      *gorm.DB
+	entries chan models.AuditLog
+	dropped atomic.Int64 // entries Log gave up on since the last report
+}
+
+func NewLogger(db *gorm.DB) *Logger {
+	return &Logger{
+		db:      db,
+		entries: make(chan models.AuditLog, queueSize),
+	}
+}
+
+// Run writes queued entries until ctx is cancelled, then writes what is
+// left.
+func (l *Logger) Run(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.AuditLog, 0, batchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			l.write(batch)
+			batch = make([]models.AuditLog, 0, batchSize)
+		}
+	}
+
+	for {
+		select {
+		case entry := <-l.entries:
+			batch = append(batch, entry)
+			if len(batch) == batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+			if dropped := l.dropped.Swap(0); dropped > 0 {
+				log.Printf("Dropped %d audit log entries: the writer fell behind", dropped)
+			}
+		case <-ctx.Done():
+			for {
+				select {
+				case entry := <-l.entries:
+					batch = append(batch, entry)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// write appends a batch to the chain. If the batch is refused, its entries
+// are retried one at a time so a single bad entry doesn't lose the rest.
+func (l *Logger) write(batch []models.AuditLog) {
+	err := appendToChain(l.db, batch)
+	if err == nil {
+		return
+	}
+	if len(batch) == 1 {
+		log.Printf("Failed to write audit log entry %q: %v", batch[0].Action, err)
+		return
+	}
+
+	log.Printf("Failed to write %d audit log entries, retrying one by one: %v", len(batch), err)
+	for _, entry := range batch {
+		entry.ID = 0
+		if err := appendToChain(l.db, []models.AuditLog{entry}); err != nil {
+			log.Printf("Failed to write audit log entry %q: %v", entry.Action, err)
+		}
+	}
+}
+
+// Log queues an entry. If the writer has fallen behind and the queue is
+// full, it waits up to enqueueTimeout for room and then drops the entry;
+// Run logs how many were dropped.
+func (l *Logger) Log(entry models.AuditLog) {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	entry = normalize(entry)
+
+	select {
+	case l.entries <- entry:
+		return
+	default:
+	}
+
+	timer := time.NewTimer(enqueueTimeout)
+	defer timer.Stop()
+	select {
+	case l.entries <- entry:
+	case <-timer.C:
+		l.dropped.Add(1)
+	}
+}
+
+// Record logs an action taken by the authenticated user of request c.
+// Actions taken with an API key note the key in their metadata. Metadata
+// must not hold strings borrowed from c, such as c.Params values.
+func (l *Logger) Record(c *fiber.Ctx, action, resourceType string, resourceID *uint, metadata map[string]interface{}) {
+	var actorID *uint
+	if userID, ok := c.Locals("user_id").(float64); ok {
+		id := uint(userID)
+		actorID = &id
+	}
+	l.RecordAs(c, actorID, action, resourceType, resourceID, metadata)
+}
+
+// RecordAs is Record for requests that aren't authenticated yet, such as
+// logins, where the actor is known some other way.
+func (l *Logger) RecordAs(c *fiber.Ctx, actorID *uint, action, resourceType string, resourceID *uint, metadata map[string]interface{}) {
+	if keyID, ok := c.Locals("api_key_id").(uint); ok {
+		withKey := map[string]interface{}{"api_key_id": keyID}
+		for k, v := range metadata {
+			withKey[k] = v
+		}
+		metadata = withKey
+	}
+
+	// Fiber reuses the request's buffers once the handler returns, so
+	// strings taken from it are copied
+	l.Log(models.AuditLog{
+		UserID:       actorID,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   copyID(resourceID),
+		IP:           strings.Clone(c.IP()),
+		UserAgent:    strings.Clone(c.Get("User-Agent")),
+		Metadata:     metadata,
+	})
+}
+
+// copyID detaches an ID from the struct it points into, which the caller
+// may change after the entry is queued.
+func copyID(id *uint) *uint {
+	if id == nil {
+		return nil
+	}
+	value := *id
+	return &value
+}
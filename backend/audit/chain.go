@@ -0,0 +1,215 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"gaming-panel/backend/models"
+
+	"gorm.io/gorm"
+)
+
+// chainLock is the Postgres advisory lock held while appending to the chain,
+// so writers on every API replica take turns and the chain never forks.
+const chainLock = 0x61756469 // "audi"
+
+// chainedEntry is what an entry's hash covers. Changing it invalidates
+// every existing hash.
+type chainedEntry struct {
+	PrevHash     string                 `json:"prev_hash"`
+	UserID       *uint                  `json:"user_id"`
+	Action       string                 `json:"action"`
+	ResourceType string                 `json:"resource_type"`
+	ResourceID   *uint                  `json:"resource_id"`
+	IP           string                 `json:"ip"`
+	UserAgent    string                 `json:"user_agent"`
+	Metadata     map[string]interface{} `json:"metadata"`
+	CreatedAt    string                 `json:"created_at"`
+}
+
+func hashEntry(entry models.AuditLog) string {
+	raw, _ := json.Marshal(chainedEntry{
+		PrevHash:     entry.PrevHash,
+		UserID:       entry.UserID,
+		Action:       entry.Action,
+		ResourceType: entry.ResourceType,
+		ResourceID:   entry.ResourceID,
+		IP:           entry.IP,
+		UserAgent:    entry.UserAgent,
+		Metadata:     entry.Metadata,
+		CreatedAt:    entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalize puts an entry in the form it has after a trip through the
+// database, so its hash can be recomputed from what is stored: times lose
+// their nanoseconds, metadata numbers become float64 and text must be valid
+// UTF-8 without NULs, which Postgres refuses in text and jsonb.
+func normalize(entry models.AuditLog) models.AuditLog {
+	entry.CreatedAt = entry.CreatedAt.Truncate(time.Microsecond)
+	entry.Action = cleanText(entry.Action)
+	entry.ResourceType = cleanText(entry.ResourceType)
+	entry.IP = cleanText(entry.IP)
+	entry.UserAgent = cleanText(entry.UserAgent)
+
+	if entry.Metadata != nil {
+		raw, err := json.Marshal(entry.Metadata)
+		var metadata map[string]interface{}
+		if err == nil && json.Unmarshal(raw, &metadata) == nil {
+			entry.Metadata = cleanValue(metadata).(map[string]interface{})
+		} else {
+			entry.Metadata = map[string]interface{}{"error": "metadata could not be encoded"}
+		}
+	}
+	return entry
+}
+
+// cleanText replaces invalid UTF-8 and NULs with U+FFFD.
+func cleanText(s string) string {
+	return strings.ReplaceAll(strings.ToValidUTF8(s, "\uFFFD"), "\x00", "\uFFFD")
+}
+
+// cleanValue applies cleanText to every string in decoded JSON, map keys
+// included.
+func cleanValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return cleanText(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = cleanValue(v[i])
+		}
+		return v
+	case map[string]interface{}:
+		cleaned := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			cleaned[cleanText(key)] = cleanValue(item)
+		}
+		return cleaned
+	default:
+		return v
+	}
+}
+
+// appendToChain links batch to the end of the chain and inserts it.
+func appendToChain(db *gorm.DB, batch []models.AuditLog) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", chainLock).Error; err != nil {
+			return err
+		}
+
+		var last models.AuditLog
+		if err := tx.Select("hash").Order("id DESC").Limit(1).Find(&last).Error; err != nil {
+			return err
+		}
+
+		prev := last.Hash
+		for i := range batch {
+			batch[i].PrevHash = prev
+			batch[i].Hash = hashEntry(batch[i])
+			prev = batch[i].Hash
+		}
+		return tx.CreateInBatches(batch, batchSize).Error
+	})
+}
+
+// SealExisting chains entries written before the log had a hash chain, in
+// the order they were written. It does nothing once every entry is sealed.
+func SealExisting(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", chainLock).Error; err != nil {
+			return err
+		}
+
+		var first []models.AuditLog
+		if err := tx.Select("id").Where("hash = ''").Order("id").Limit(1).Find(&first).Error; err != nil {
+			return err
+		}
+		if len(first) == 0 {
+			return nil
+		}
+
+		var last models.AuditLog
+		if err := tx.Select("hash").Where("id < ?", first[0].ID).Order("id DESC").Limit(1).Find(&last).Error; err != nil {
+			return err
+		}
+		prev := last.Hash
+
+		var entries []models.AuditLog
+		return tx.Where("id >= ?", first[0].ID).Order("id").
+			FindInBatches(&entries, exportBatchSize, func(_ *gorm.DB, _ int) error {
+				for _, entry := range entries {
+					if entry.Hash == "" {
+						entry.PrevHash = prev
+						entry.Hash = hashEntry(entry)
+						if err := tx.Model(&models.AuditLog{}).
+							Where("id = ?", entry.ID).
+							Updates(map[string]interface{}{"prev_hash": entry.PrevHash, "hash": entry.Hash}).Error; err != nil {
+							return err
+						}
+					}
+					prev = entry.Hash
+				}
+				return nil
+			}).Error
+	})
+}
+
+// Verification is the result of checking the chain.
+type Verification struct {
+	Valid    bool   `json:"valid"`
+	Checked  int64  `json:"checked"`             // intact entries, oldest first
+	Head     string `json:"head,omitempty"`      // hash of the newest entry
+	BrokenAt *uint  `json:"broken_at,omitempty"` // the first entry that doesn't link up
+	Reason   string `json:"reason,omitempty"`    // why it doesn't
+}
+
+// Verify walks the chain from the oldest entry and stops at the first broken
+// link. Removing the newest entries leaves a valid but shorter chain, so the
+// head should also be compared with one recorded earlier.
+func Verify(ctx context.Context, db *gorm.DB) (Verification, error) {
+	var result Verification
+	var prev string
+	var after uint
+
+	for {
+		var batch []models.AuditLog
+		if err := db.WithContext(ctx).Where("id > ?", after).Order("id").Limit(exportBatchSize).Find(&batch).Error; err != nil {
+			return result, err
+		}
+
+		for _, entry := range batch {
+			reason := ""
+			switch {
+			case entry.Hash == "":
+				reason = "entry is not sealed"
+			case entry.PrevHash != prev:
+				reason = "previous hash does not match the entry before it; entries were removed or inserted"
+			case hashEntry(entry) != entry.Hash:
+				reason = "contents do not match the hash; the entry was modified"
+			}
+			if reason != "" {
+				id := entry.ID
+				result.BrokenAt = &id
+				result.Reason = reason
+				return result, nil
+			}
+
+			prev = entry.Hash
+			result.Checked++
+		}
+
+		if len(batch) < exportBatchSize {
+			result.Valid = true
+			result.Head = prev
+			return result, nil
+		}
+		after = batch[len(batch)-1].ID
+	}
+}
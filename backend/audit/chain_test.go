@@ -0,0 +1,43 @@
+package audit
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"gaming-panel/backend/models"
+)
+
+// Postgres refuses NULs in text and jsonb, so none may survive normalize,
+// wherever they are in the entry.
+func TestNormalizeCleansText(t *testing.T) {
+	entry := normalize(models.AuditLog{
+		Action:    "server.command",
+		IP:        "127.0.0.1\x00",
+		UserAgent: "curl\x00\xff",
+		Metadata: map[string]interface{}{
+			"command": "say \x00hi\xc3",
+			"nested":  map[string]interface{}{"list": []interface{}{"a\x00"}},
+			"key\x00": 1,
+		},
+		CreatedAt: time.Now(),
+	})
+
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), `\u0000`) || strings.Contains(entry.IP+entry.UserAgent, "\x00") {
+		t.Errorf("NUL survived normalize: %s", raw)
+	}
+	if got := entry.Metadata["command"]; got != "say �hi�" {
+		t.Errorf("command = %q", got)
+	}
+
+	// What is stored must hash the same as what was written
+	again := normalize(entry)
+	if hashEntry(again) != hashEntry(entry) {
+		t.Error("normalize is not idempotent")
+	}
+}
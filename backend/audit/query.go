@@ -0,0 +1,322 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"gaming-panel/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+	exportBatchSize = 500
+)
+
+// Filter narrows an audit log query. Zero fields match everything.
+type Filter struct {
+	UserID       *uint
+	Action       string // exact, or a prefix such as "server.*"
+	ResourceType string
+	ResourceID   *uint
+	IP           string // an address or a CIDR range
+	From         time.Time
+	To           time.Time // exclusive
+
+	// ClientOf, if set, hides the IP and user agent of entries by anyone
+	// but this user, and IP only matches their entries
+	ClientOf *uint
+}
+
+// Entry is an audit log entry with its actor's username.
+type Entry struct {
+	models.AuditLog
+	Username string `json:"username,omitempty"`
+}
+
+// ParseFilter reads a Filter from the user_id, action, resource_type,
+// resource_id, ip, from and to query parameters. Times are RFC 3339.
+func ParseFilter(c *fiber.Ctx) (Filter, error) {
+	var filter Filter
+
+	if value := c.Query("user_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 0)
+		if err != nil {
+			return filter, errors.New("user_id must be a user ID")
+		}
+		userID := uint(id)
+		filter.UserID = &userID
+	}
+
+	if value := c.Query("resource_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 0)
+		if err != nil {
+			return filter, errors.New("resource_id must be an ID")
+		}
+		resourceID := uint(id)
+		filter.ResourceID = &resourceID
+	}
+
+	// Query values are borrowed from the request, and exports read the
+	// filter after the handler returns
+	filter.Action = strings.Clone(strings.TrimSpace(c.Query("action")))
+	filter.ResourceType = strings.Clone(strings.TrimSpace(c.Query("resource_type")))
+
+	if value := strings.TrimSpace(c.Query("ip")); value != "" {
+		if _, network, err := net.ParseCIDR(value); err == nil {
+			filter.IP = network.String()
+		} else if ip := net.ParseIP(value); ip != nil {
+			filter.IP = ip.String()
+		} else {
+			return filter, errors.New("ip must be an IP address or CIDR range")
+		}
+	}
+
+	for _, bound := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		if value := c.Query(bound.name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 time", bound.name)
+			}
+			*bound.dst = t
+		}
+	}
+
+	return filter, nil
+}
+
+// query selects the entries matching f, newest first.
+func (f Filter) query(db *gorm.DB) *gorm.DB {
+	// Deleted users keep their name in the log
+	query := db.Model(&models.AuditLog{}).
+		Select("audit_logs.*, users.username AS username").
+		Joins("LEFT JOIN users ON users.id = audit_logs.user_id").
+		Order("audit_logs.id DESC")
+
+	if f.UserID != nil {
+		query = query.Where("audit_logs.user_id = ?", *f.UserID)
+	}
+	if prefix, ok := strings.CutSuffix(f.Action, "*"); ok {
+		query = query.Where("audit_logs.action LIKE ? ESCAPE '\\'", escapeLike(prefix)+"%")
+	} else if f.Action != "" {
+		query = query.Where("audit_logs.action = ?", f.Action)
+	}
+	if f.ResourceType != "" {
+		query = query.Where("audit_logs.resource_type = ?", f.ResourceType)
+	}
+	if f.ResourceID != nil {
+		query = query.Where("audit_logs.resource_id = ?", *f.ResourceID)
+	}
+	if strings.Contains(f.IP, "/") {
+		query = query.Where("CAST(NULLIF(audit_logs.ip, '') AS inet) <<= CAST(? AS cidr)", f.IP)
+	} else if f.IP != "" {
+		query = query.Where("audit_logs.ip = ?", f.IP)
+	}
+	if f.IP != "" && f.ClientOf != nil {
+		query = query.Where("audit_logs.user_id = ?", *f.ClientOf)
+	}
+	if !f.From.IsZero() {
+		query = query.Where("audit_logs.created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		query = query.Where("audit_logs.created_at < ?", f.To)
+	}
+	return query
+}
+
+// redact hides what f.ClientOf may not see of entry.
+func (f Filter) redact(entry *Entry) {
+	if f.ClientOf != nil && (entry.UserID == nil || *entry.UserID != *f.ClientOf) {
+		entry.IP = ""
+		entry.UserAgent = ""
+	}
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// Cursors are opaque to clients so the ordering can change later.
+func encodeCursor(id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(id), 10)))
+}
+
+func decodeCursor(cursor string) (uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(string(raw), 10, 0)
+	return uint(id), err
+}
+
+// Serve responds with the entries matching filter, newest first. Pages of
+// ?limit= entries continue from ?cursor=, the next_cursor of the previous
+// page. With ?format=csv or ?format=ndjson every matching entry is streamed
+// as a download named after filename.
+func Serve(c *fiber.Ctx, db *gorm.DB, filter Filter, filename string) error {
+	switch format := c.Query("format", "json"); format {
+	case "json":
+		return servePage(c, db, filter)
+	case "csv", "ndjson":
+		return serveExport(c, db, filter, filename, format)
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "format must be json, csv or ndjson",
+		})
+	}
+}
+
+func servePage(c *fiber.Ctx, db *gorm.DB, filter Filter) error {
+	limit := c.QueryInt("limit", defaultPageSize)
+	if limit < 1 || limit > maxPageSize {
+		limit = defaultPageSize
+	}
+
+	query := filter.query(db)
+	if cursor := c.Query("cursor"); cursor != "" {
+		before, err := decodeCursor(cursor)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid cursor",
+			})
+		}
+		query = query.Where("audit_logs.id < ?", before)
+	}
+
+	// One extra entry tells whether there is another page
+	entries := []Entry{}
+	if err := query.Limit(limit + 1).Find(&entries).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch audit log",
+		})
+	}
+
+	var nextCursor *string
+	if len(entries) > limit {
+		entries = entries[:limit]
+		next := encodeCursor(entries[limit-1].ID)
+		nextCursor = &next
+	}
+	for i := range entries {
+		filter.redact(&entries[i])
+	}
+
+	return c.JSON(fiber.Map{
+		"data":        entries,
+		"next_cursor": nextCursor,
+	})
+}
+
+// serveExport streams entries in batches, so exports of any size use little
+// memory. Errors after the first byte can only be logged.
+func serveExport(c *fiber.Ctx, db *gorm.DB, filter Filter, filename, format string) error {
+	name := fmt.Sprintf("%s-%s.%s", filename, time.Now().UTC().Format("20060102T150405Z"), format)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, name))
+
+	if format == "csv" {
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	} else {
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	}
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		encoder := json.NewEncoder(w)
+		var table *csv.Writer
+		if format == "csv" {
+			table = csv.NewWriter(w)
+			table.Write(csvHeader)
+		}
+		flush := func() error {
+			if table != nil {
+				table.Flush()
+			}
+			return w.Flush()
+		}
+
+		var before uint
+		for {
+			query := filter.query(db)
+			if before > 0 {
+				query = query.Where("audit_logs.id < ?", before)
+			}
+
+			var batch []Entry
+			if err := query.Limit(exportBatchSize).Find(&batch).Error; err != nil {
+				log.Printf("Audit log export failed: %v", err)
+				flush()
+				return
+			}
+			for _, entry := range batch {
+				filter.redact(&entry)
+				if table != nil {
+					table.Write(csvRecord(entry))
+				} else {
+					encoder.Encode(entry)
+				}
+			}
+
+			// Stop early if the client went away
+			if err := flush(); err != nil || len(batch) < exportBatchSize {
+				return
+			}
+			before = batch[len(batch)-1].ID
+		}
+	})
+	return nil
+}
+
+var csvHeader = []string{"id", "created_at", "user_id", "username", "action", "resource_type", "resource_id", "ip", "user_agent", "metadata", "prev_hash", "hash"}
+
+func csvRecord(entry Entry) []string {
+	metadata := ""
+	if len(entry.Metadata) > 0 {
+		raw, _ := json.Marshal(entry.Metadata)
+		metadata = string(raw)
+	}
+	return []string{
+		strconv.FormatUint(uint64(entry.ID), 10),
+		entry.CreatedAt.UTC().Format(time.RFC3339),
+		formatID(entry.UserID),
+		csvText(entry.Username),
+		csvText(entry.Action),
+		csvText(entry.ResourceType),
+		formatID(entry.ResourceID),
+		entry.IP,
+		csvText(entry.UserAgent),
+		metadata,
+		entry.PrevHash,
+		entry.Hash,
+	}
+}
+
+func formatID(id *uint) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*id), 10)
+}
+
+// csvText keeps spreadsheets from evaluating user-supplied text as a
+// formula.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,34 @@
+package audit
+
+import (
+	"testing"
+
+	"gaming-panel/backend/models"
+)
+
+// Only the entries of the user named by ClientOf keep their IP and user
+// agent; entries without a user are hidden too.
+func TestRedactClientOf(t *testing.T) {
+	owner, subuser := uint(1), uint(2)
+	entry := func(userID *uint) Entry {
+		return Entry{AuditLog: models.AuditLog{UserID: userID, IP: "192.0.2.1", UserAgent: "curl"}}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		clientOf *uint
+		userID   *uint
+		visible  bool
+	}{
+		{"no restriction", nil, &subuser, true},
+		{"own entry", &owner, &owner, true},
+		{"someone else's entry", &owner, &subuser, false},
+		{"entry without a user", &owner, nil, false},
+	} {
+		e := entry(tc.userID)
+		Filter{ClientOf: tc.clientOf}.redact(&e)
+		if visible := e.IP != "" && e.UserAgent != ""; visible != tc.visible {
+			t.Errorf("%s: IP %q, user agent %q, want visible = %v", tc.name, e.IP, e.UserAgent, tc.visible)
+		}
+	}
+}
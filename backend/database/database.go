@@ -18,8 +18,47 @@ func Connect(databaseURL string) (*gorm.DB, error) {
 	return db, nil
 }
 
+// protectAuditLog makes audit log entries append-only. The only update
+// allowed seals an entry written before the hash chain existed.
+const protectAuditLog = `
+CREATE OR REPLACE FUNCTION audit_logs_append_only() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'UPDATE' AND OLD.hash = '' THEN
+		RETURN NEW;
+	END IF;
+	RAISE EXCEPTION 'audit log entries cannot be changed or deleted';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_logs_append_only ON audit_logs;
+CREATE TRIGGER audit_logs_append_only
+	BEFORE UPDATE OR DELETE ON audit_logs
+	FOR EACH ROW EXECUTE FUNCTION audit_logs_append_only();
+`
+
+// partialAllocationIndex drops idx_allocation if it still covers deleted
+// allocations, which kept a deleted node's ports taken. AutoMigrate then
+// creates it again for live allocations only.
+const partialAllocationIndex = `
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM pg_indexes WHERE indexname = 'idx_allocation' AND indexdef NOT LIKE '%WHERE%') THEN
+		DROP INDEX idx_allocation;
+	END IF;
+END
+$$;
+`
+
 func Migrate(db *gorm.DB) error {
-	return db.AutoMigrate(
+	if err := db.Exec(partialAllocationIndex).Error; err != nil {
+		return err
+	}
+	// Users from before email verification count as verified, or they
+	// would be locked out
+	backfillVerified := db.Migrator().HasTable(&models.User{}) &&
+		!db.Migrator().HasColumn(&models.User{}, "EmailVerifiedAt")
+
+	err := db.AutoMigrate(
 		&models.User{},
 		&models.Role{},
 		&models.Server{},
@@ -27,5 +66,20 @@ func Migrate(db *gorm.DB) error {
 		&models.Allocation{},
 		&models.Backup{},
 		&models.AuditLog{},
+		&models.Subuser{},
+		&models.APIKey{},
+		&models.RecoveryCode{},
+		&models.UserIdentity{},
 	)
+	if err != nil {
+		return err
+	}
+
+	if backfillVerified {
+		if err := db.Exec("UPDATE users SET email_verified_at = created_at WHERE email_verified_at IS NULL").Error; err != nil {
+			return err
+		}
+	}
+
+	return db.Exec(protectAuditLog).Error
 }
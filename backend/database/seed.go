@@ -0,0 +1,25 @@
+package database
+
+import (
+	"fmt"
+
+	"gaming-panel/backend/models"
+
+	"gorm.io/gorm"
+)
+
+// SeedRoles makes sure the built-in roles exist. Roles that already exist are
+// left alone so that edits to their permissions survive restarts.
+func SeedRoles(db *gorm.DB) error {
+	roles := []models.Role{
+		{Name: models.RoleAdmin, Permissions: models.Permissions{models.PermissionAll: true}},
+		{Name: models.RoleUser, Permissions: models.DefaultUserPermissions},
+	}
+
+	for _, role := range roles {
+		if err := db.Where("name = ?", role.Name).FirstOrCreate(&role).Error; err != nil {
+			return fmt.Errorf("failed to seed role %s: %w", role.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,364 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"gaming-panel/backend/middleware"
+	"gaming-panel/backend/models"
+	"gaming-panel/backend/websocket/hub"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// channels are the daemon events the backend consumes. Every event is
+// signed with the key of the node it comes from.
+var channels = []string{
+	"server:status",
+	"backup:status",
+	"backup:restore",
+	"server:console",
+	"node:heartbeat",
+}
+
+const (
+	leaderKey      = "events:leader"
+	leaderTTL      = 15 * time.Second
+	leaderInterval = 5 * time.Second
+)
+
+// renewLeadership extends the lease only if this instance still holds it.
+var renewLeadership = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseLeadership gives up the lease only if this instance still holds it.
+var releaseLeadership = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Subscriber consumes the events daemons publish on Redis and applies them
+// to the database. Every backend replica runs one, but only the replica
+// holding the leader lease in Redis consumes events, so each event is
+// applied and broadcast exactly once.
+type Subscriber struct {
+	db          *gorm.DB
+	redisClient *redis.Client
+	wsHub       *hub.Hub
+	instanceID  string
+
+	// Console output arrives line by line, so servers are cached rather
+	// than looked up for every line
+	serversMu sync.Mutex
+	servers   map[uint]serverHost
+}
+
+// serverHost is what events about a server need: the WebSocket room to
+// relay them to and the key of the node allowed to send them.
+type serverHost struct {
+	uuid      string
+	tokenHash string
+}
+
+func NewSubscriber(db *gorm.DB, redisClient *redis.Client, wsHub *hub.Hub) *Subscriber {
+	return &Subscriber{
+		db:          db,
+		redisClient: redisClient,
+		wsHub:       wsHub,
+		instanceID:  uuid.New().String(),
+		servers:     make(map[uint]serverHost),
+	}
+}
+
+func (s *Subscriber) Start(ctx context.Context) {
+	ticker := time.NewTicker(leaderInterval)
+	defer ticker.Stop()
+
+	for {
+		acquired, err := s.redisClient.SetNX(ctx, leaderKey, s.instanceID, leaderTTL).Result()
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to acquire event leader lease: %v", err)
+		}
+		if acquired {
+			log.Printf("Consuming daemon events as leader %s", s.instanceID)
+			s.consume(ctx)
+			log.Printf("Stopped consuming daemon events")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// consume handles events until ctx is cancelled or the lease is lost.
+func (s *Subscriber) consume(ctx context.Context) {
+	pubsub := s.redisClient.Subscribe(ctx, channels...)
+	defer pubsub.Close()
+
+	ch := pubsub.Channel()
+	ticker := time.NewTicker(leaderInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Hand the lease over straight away on shutdown
+			releaseLeadership.Run(context.Background(), s.redisClient, []string{leaderKey}, s.instanceID)
+			return
+
+		case <-ticker.C:
+			renewed, err := renewLeadership.Run(ctx, s.redisClient, []string{leaderKey}, s.instanceID, leaderTTL.Milliseconds()).Int()
+			if err != nil || renewed == 0 {
+				log.Printf("Lost event leader lease: %v", err)
+				return
+			}
+			s.markSilentNodesOffline()
+
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			s.handleMessage(msg)
+		}
+	}
+}
+
+func (s *Subscriber) handleMessage(msg *redis.Message) {
+	switch msg.Channel {
+	case "server:status":
+		s.handleServerStatus(msg.Channel, msg.Payload)
+	case "backup:status":
+		s.handleBackupStatus(msg.Channel, msg.Payload)
+	case "backup:restore":
+		s.handleRestoreStatus(msg.Channel, msg.Payload)
+	case "server:console":
+		s.handleConsole(msg.Channel, msg.Payload)
+	case "node:heartbeat":
+		s.handleHeartbeat(msg.Channel, msg.Payload)
+	}
+}
+
+type serverStatus struct {
+	ServerID uint                `json:"server_id"`
+	Status   models.ServerStatus `json:"status"`
+	Error    string              `json:"error"`
+	At       int64               `json:"at"` // daemon clock, in nanoseconds
+}
+
+// handleServerStatus records the outcome of a power action reported by the
+// daemon. A failed action carries an error the UI can show next to the status.
+func (s *Subscriber) handleServerStatus(channel, data string) {
+	var event serverStatus
+	signed, err := openEvent(data, &event)
+	if err != nil || event.ServerID == 0 || event.At == 0 {
+		log.Printf("Invalid server status event: %s", data)
+		return
+	}
+	if !event.Status.Valid() {
+		log.Printf("Unknown status %q for server %d", event.Status, event.ServerID)
+		return
+	}
+
+	serverUUID, err := s.verifyServerEvent(signed, channel, event.ServerID)
+	if err != nil {
+		log.Printf("Rejected status event for server %d: %v", event.ServerID, err)
+		return
+	}
+
+	applied, err := applyServerStatus(s.db, event)
+	if err != nil {
+		log.Printf("Failed to update status of server %d: %v", event.ServerID, err)
+		return
+	}
+	if !applied {
+		log.Printf("Dropped stale %s status event for server %d", event.Status, event.ServerID)
+		return
+	}
+
+	message := map[string]interface{}{
+		"type":   "server.status",
+		"status": event.Status,
+	}
+	if event.Error != "" {
+		message["error"] = event.Error
+	}
+	s.wsHub.BroadcastToServer(serverUUID, message)
+}
+
+// applyServerStatus records event unless the daemon has reported a later
+// status already, since the outcomes of concurrent jobs may arrive out of
+// order. It reports whether the event was applied.
+func applyServerStatus(db *gorm.DB, event serverStatus) (bool, error) {
+	at := time.Unix(0, event.At)
+	result := db.Model(&models.Server{}).
+		Where("id = ? AND (status_at IS NULL OR status_at <= ?)", event.ServerID, at).
+		Updates(map[string]interface{}{
+			"status":       event.Status,
+			"status_error": event.Error,
+			"status_at":    at,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+type backupStatus struct {
+	BackupID uint   `json:"backup_id"`
+	ServerID uint   `json:"server_id"`
+	Success  bool   `json:"success"`
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+	Error    string `json:"error"`
+}
+
+func (s *Subscriber) handleBackupStatus(channel, data string) {
+	var event backupStatus
+	signed, err := openEvent(data, &event)
+	if err != nil || event.BackupID == 0 {
+		log.Printf("Invalid backup status event: %s", data)
+		return
+	}
+
+	var backup models.Backup
+	if err := s.db.First(&backup, event.BackupID).Error; err != nil {
+		log.Printf("Backup %d not found: %v", event.BackupID, err)
+		return
+	}
+	if backup.ServerID != event.ServerID {
+		log.Printf("Backup %d does not belong to server %d", event.BackupID, event.ServerID)
+		return
+	}
+	serverUUID, err := s.verifyServerEvent(signed, channel, backup.ServerID)
+	if err != nil {
+		log.Printf("Rejected status event for backup %d: %v", backup.ID, err)
+		return
+	}
+
+	now := time.Now()
+	backup.IsSuccess = event.Success
+	backup.Path = event.Path
+	backup.Size = event.Size
+	backup.Checksum = event.Checksum
+	backup.Error = event.Error
+	backup.CompletedAt = &now
+
+	if err := s.db.Omit("Server").Save(&backup).Error; err != nil {
+		log.Printf("Failed to update backup %d: %v", backup.ID, err)
+		return
+	}
+
+	eventType := "backup.completed"
+	if !backup.IsSuccess {
+		eventType = "backup.failed"
+	}
+	s.wsHub.BroadcastToServer(serverUUID, map[string]interface{}{
+		"type":   eventType,
+		"backup": backup,
+	})
+}
+
+type restoreStatus struct {
+	ServerID uint   `json:"server_id"`
+	BackupID uint   `json:"backup_id"`
+	Stage    string `json:"stage"`
+	Error    string `json:"error"`
+}
+
+// handleRestoreStatus relays restore progress to the server's WebSocket room.
+func (s *Subscriber) handleRestoreStatus(channel, data string) {
+	var event restoreStatus
+	signed, err := openEvent(data, &event)
+	if err != nil || event.ServerID == 0 {
+		log.Printf("Invalid restore status event: %s", data)
+		return
+	}
+
+	serverUUID, err := s.verifyServerEvent(signed, channel, event.ServerID)
+	if err != nil {
+		log.Printf("Rejected restore event for server %d: %v", event.ServerID, err)
+		return
+	}
+
+	message := map[string]interface{}{
+		"type":      "backup.restore",
+		"backup_id": event.BackupID,
+		"stage":     event.Stage,
+	}
+	if event.Error != "" {
+		message["error"] = event.Error
+	}
+	s.wsHub.BroadcastToServer(serverUUID, message)
+}
+
+type consoleLine struct {
+	ServerID uint   `json:"server_id"`
+	Stream   string `json:"stream"`
+	Line     string `json:"line"`
+}
+
+func (s *Subscriber) handleConsole(channel, data string) {
+	var event consoleLine
+	signed, err := openEvent(data, &event)
+	if err != nil || event.ServerID == 0 {
+		log.Printf("Invalid console event: %s", data)
+		return
+	}
+
+	serverUUID, err := s.verifyServerEvent(signed, channel, event.ServerID)
+	if err != nil {
+		log.Printf("Rejected console event for server %d: %v", event.ServerID, err)
+		return
+	}
+
+	s.wsHub.PublishConsole(serverUUID, event.Stream, event.Line)
+}
+
+// openEvent decodes a signed daemon event into event. Its signature can
+// only be checked once the payload says which server it is about.
+func openEvent(data string, event interface{}) (middleware.NodeMessage, error) {
+	message, err := middleware.ParseNodeMessage(data)
+	if err != nil {
+		return message, err
+	}
+	return message, json.Unmarshal([]byte(message.Payload), event)
+}
+
+// verifyServerEvent checks that message was signed by the node hosting the
+// server, so a node can only report on its own servers, and returns the
+// server's UUID.
+func (s *Subscriber) verifyServerEvent(message middleware.NodeMessage, channel string, serverID uint) (string, error) {
+	s.serversMu.Lock()
+	host, ok := s.servers[serverID]
+	s.serversMu.Unlock()
+	if ok && message.Verify(host.tokenHash, channel) == nil {
+		return host.uuid, nil
+	}
+
+	// Not cached yet, or the node has enrolled again with a new token
+	var server models.Server
+	err := s.db.Select("id", "uuid", "node_id").
+		Preload("Node", func(db *gorm.DB) *gorm.DB { return db.Select("id", "token_hash") }).
+		First(&server, serverID).Error
+	if err != nil {
+		return "", err
+	}
+	host = serverHost{uuid: server.UUID, tokenHash: server.Node.TokenHash}
+
+	s.serversMu.Lock()
+	s.servers[serverID] = host
+	s.serversMu.Unlock()
+	return host.uuid, message.Verify(host.tokenHash, channel)
+}
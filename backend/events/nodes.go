@@ -0,0 +1,99 @@
+package events
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"gaming-panel/backend/middleware"
+	"gaming-panel/backend/models"
+
+	"gorm.io/gorm/clause"
+)
+
+// heartbeatTimeout is how long a node may go without a heartbeat before it
+// is marked offline. Daemons send one every 15 seconds.
+const heartbeatTimeout = time.Minute
+
+// heartbeat leaves out the figures the daemon couldn't read, which keep
+// their last known values.
+type heartbeat struct {
+	NodeID        uint   `json:"node_id"`
+	DaemonVersion string `json:"daemon_version"`
+	DockerVersion string `json:"docker_version"`
+	TotalRAM      *int64 `json:"total_ram"`
+	TotalCPU      *int64 `json:"total_cpu"`
+	TotalDisk     *int64 `json:"total_disk"`
+	UsedRAM       *int64 `json:"used_ram"`
+	UsedCPU       *int64 `json:"used_cpu"`
+	UsedDisk      *int64 `json:"used_disk"`
+}
+
+// handleHeartbeat marks a node online and records the resources its daemon
+// reported. Heartbeats must be signed with the key of the node they are for.
+func (s *Subscriber) handleHeartbeat(channel, data string) {
+	message, err := middleware.ParseNodeMessage(data)
+	var event heartbeat
+	if err != nil || json.Unmarshal([]byte(message.Payload), &event) != nil || event.NodeID == 0 {
+		log.Printf("Invalid heartbeat: %s", data)
+		return
+	}
+
+	var node models.Node
+	if err := s.db.Select("id", "status", "token_hash").First(&node, event.NodeID).Error; err != nil {
+		log.Printf("Node %d not found for heartbeat: %v", event.NodeID, err)
+		return
+	}
+	if err := message.Verify(node.TokenHash, channel); err != nil {
+		log.Printf("Rejected heartbeat for node %d: bad or stale signature", node.ID)
+		return
+	}
+
+	updates := map[string]interface{}{
+		"status":            models.NodeStatusOnline,
+		"last_heartbeat_at": time.Now(),
+		"daemon_version":    event.DaemonVersion,
+	}
+	if event.DockerVersion != "" {
+		updates["docker_version"] = event.DockerVersion
+	}
+	for column, value := range map[string]*int64{
+		"total_ram":  event.TotalRAM,
+		"total_cpu":  event.TotalCPU,
+		"total_disk": event.TotalDisk,
+		"used_ram":   event.UsedRAM,
+		"used_cpu":   event.UsedCPU,
+		"used_disk":  event.UsedDisk,
+	} {
+		if value != nil {
+			updates[column] = *value
+		}
+	}
+
+	if err := s.db.Model(&node).Updates(updates).Error; err != nil {
+		log.Printf("Failed to record heartbeat of node %d: %v", node.ID, err)
+		return
+	}
+
+	if node.Status != models.NodeStatusOnline {
+		log.Printf("Node %d is online", node.ID)
+	}
+}
+
+// markSilentNodesOffline marks nodes offline once their heartbeats stop.
+func (s *Subscriber) markSilentNodesOffline() {
+	var nodes []models.Node
+	err := s.db.Model(&nodes).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "id"}}}).
+		Where("status = ? AND (last_heartbeat_at IS NULL OR last_heartbeat_at < ?)",
+			models.NodeStatusOnline, time.Now().Add(-heartbeatTimeout)).
+		Update("status", models.NodeStatusOffline).Error
+	if err != nil {
+		log.Printf("Failed to mark silent nodes offline: %v", err)
+		return
+	}
+
+	for _, node := range nodes {
+		log.Printf("Node %d is offline: no heartbeat for %s", node.ID, heartbeatTimeout)
+	}
+}
@@ -0,0 +1,36 @@
+package mailer
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/mail"
+	"sync"
+)
+
+// LogMailer is for development: it delivers nothing. Without Out, each
+// message's recipient, subject and text body are logged; with Out, the full
+// MIME message is appended to it.
+type LogMailer struct {
+	From *mail.Address
+	Out  io.Writer
+
+	mu sync.Mutex
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	if m.Out == nil {
+		log.Printf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Text)
+		return nil
+	}
+
+	data, err := encode(m.From, msg)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err = m.Out.Write(append(data, "\r\n\r\n"...))
+	return err
+}
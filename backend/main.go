@@ -1,8 +1,17 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -10,10 +19,17 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/websocket/v2"
 
+	"gaming-panel/backend/audit"
 	"gaming-panel/backend/config"
 	"gaming-panel/backend/database"
+	"gaming-panel/backend/events"
+	"gaming-panel/backend/mailer"
+	"gaming-panel/backend/models"
 	"gaming-panel/backend/routes"
 	"gaming-panel/backend/websocket/hub"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 func main() {
@@ -30,18 +46,62 @@ func main() {
 	if err := database.Migrate(db); err != nil {
 		log.Fatalf("Failed to run migrations: %v", err)
 	}
+	if err := database.SeedRoles(db); err != nil {
+		log.Fatalf("Failed to seed roles: %v", err)
+	}
+	if err := audit.SealExisting(db); err != nil {
+		log.Fatalf("Failed to seal audit log: %v", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "create-admin" {
+		if err := createAdmin(db, os.Args[2:]); err != nil {
+			log.Fatalf("Failed to create admin: %v", err)
+		}
+		return
+	}
 
 	// Initialize Redis
 	redisClient := database.InitRedis(cfg.RedisURL)
 
+	// Initialize mail delivery
+	mail, err := mailer.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up mailer: %v", err)
+	}
+
 	// Initialize WebSocket hub
-	wsHub := hub.NewHub()
+	wsHub := hub.NewHub(redisClient)
 	go wsHub.Run()
 
+	// Consume daemon events
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscriber := events.NewSubscriber(db, redisClient, wsHub)
+	go subscriber.Start(ctx)
+
+	// Write audit log entries in the background
+	auditor := audit.NewLogger(db)
+	auditDone := make(chan struct{})
+	go func() {
+		auditor.Run(ctx)
+		close(auditDone)
+	}()
+
+	// Client IPs feed rate limits, API key allowlists, sessions and the
+	// audit log, so a proxy header is only believed from trusted proxies
+	if cfg.ProxyHeader != "" && len(cfg.TrustedProxies) == 0 {
+		log.Fatalf("PROXY_HEADER is set but TRUSTED_PROXIES is empty")
+	}
+
 	// Initialize Fiber app
 	app := fiber.New(fiber.Config{
-		AppName:      "Gaming Control Panel API",
-		ServerHeader: "Pterodactyl",
+		AppName:                 "Gaming Control Panel API",
+		ServerHeader:            "Pterodactyl",
+		ProxyHeader:             cfg.ProxyHeader,
+		EnableTrustedProxyCheck: cfg.ProxyHeader != "",
+		TrustedProxies:          cfg.TrustedProxies,
+		EnableIPValidation:      true,
 	})
 
 	// Middleware
@@ -60,6 +120,7 @@ func main() {
 	app.Use("/ws", func(c *fiber.Ctx) error {
 		if websocket.IsWebSocketUpgrade(c) {
 			c.Locals("allowed", true)
+			c.Locals("ip", c.IP())
 			return c.Next()
 		}
 		return fiber.ErrUpgradeRequired
@@ -79,7 +140,7 @@ func main() {
 
 	// API routes
 	api := app.Group("/api/v1")
-	routes.SetupRoutes(api, db, redisClient, wsHub, cfg)
+	routes.SetupRoutes(api, db, redisClient, wsHub, mail, auditor, cfg)
 
 	// Start server
 	port := os.Getenv("PORT")
@@ -87,8 +148,92 @@ func main() {
 		port = "3000"
 	}
 
+	// Shut down cleanly on SIGINT/SIGTERM so queued audit entries are
+	// written
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		log.Println("Shutting down")
+		app.Shutdown()
+	}()
+
 	log.Printf("🚀 Server starting on port %s", port)
 	if err := app.Listen(":" + port); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
+
+	cancel()
+	<-auditDone
+}
+
+// createAdmin implements `create-admin -email <email> -username <name>`,
+// which is how a panel gets its first admin. Registering never grants the
+// admin role. The password is read from ADMIN_PASSWORD, or from the first
+// line of stdin when that is unset. An existing account with the email is
+// made an admin and given the new password.
+func createAdmin(db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	email := flags.String("email", "", "the admin's email address")
+	username := flags.String("username", "admin", "the admin's username")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" || *username == "" {
+		return errors.New("-email and -username are required")
+	}
+
+	password := os.Getenv("ADMIN_PASSWORD")
+	if password == "" {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return errors.New("set ADMIN_PASSWORD or pass the password on stdin")
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if len(password) < models.MinPasswordLength {
+		return fmt.Errorf("the password must be at least %d characters", models.MinPasswordLength)
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	var role models.Role
+	if err := db.Where("name = ?", models.RoleAdmin).First(&role).Error; err != nil {
+		return fmt.Errorf("admin role is missing: %w", err)
+	}
+
+	// Whoever runs this controls the installation, so the address counts as
+	// verified
+	now := time.Now()
+	var user models.User
+	err = db.Where("email = ?", *email).First(&user).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		user = models.User{
+			Email:           *email,
+			Username:        *username,
+			PasswordHash:    string(hashedPassword),
+			RoleID:          role.ID,
+			EmailVerifiedAt: &now,
+		}
+		if err := db.Create(&user).Error; err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+		fmt.Printf("Created admin %s (user %d)\n", user.Email, user.ID)
+	case err != nil:
+		return err
+	default:
+		if err := db.Model(&user).Updates(map[string]interface{}{
+			"password_hash":     string(hashedPassword),
+			"role_id":           role.ID,
+			"email_verified_at": now,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		fmt.Printf("Made %s (user %d) an admin\n", user.Email, user.ID)
+	}
+	return nil
 }
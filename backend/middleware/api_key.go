@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strings"
+	"time"
+
+	"gaming-panel/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// APIKeyPrefix marks bearer tokens that are API keys rather than JWTs.
+const APIKeyPrefix = "gp_"
+
+// lastUsedInterval throttles last-used bookkeeping to one write per key per
+// interval.
+const lastUsedInterval = time.Minute
+
+// HashAPIKey returns the hash an API key is stored under.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// IPAllowed reports whether ip matches one of the allowlist entries, which
+// are IPs or CIDRs. An empty allowlist allows any IP.
+func IPAllowed(allowlist []string, ip string) bool {
+	if len(allowlist) == 0 {
+		return true
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, entry := range allowlist {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(parsed) {
+				return true
+			}
+		} else if allowed := net.ParseIP(entry); allowed != nil && allowed.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyAuthMiddleware is AuthMiddleware that also accepts API keys as bearer
+// tokens. Requests made with a key carry its ID and scopes in the
+// "api_key_id" and "api_key_scopes" locals, which RequirePermission checks.
+func APIKeyAuthMiddleware(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+		if !strings.HasPrefix(token, APIKeyPrefix) {
+			return AuthMiddleware(c)
+		}
+
+		var key models.APIKey
+		if err := db.Where("key_hash = ?", HashAPIKey(token)).First(&key).Error; err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid API key",
+			})
+		}
+
+		now := time.Now()
+		if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "API key has expired",
+			})
+		}
+
+		if !IPAllowed(key.AllowedIPs, c.IP()) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "API key is not allowed from this IP address",
+			})
+		}
+
+		if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > lastUsedInterval || key.LastUsedIP != c.IP() {
+			db.Model(&key).UpdateColumns(map[string]interface{}{
+				"last_used_at": now,
+				"last_used_ip": c.IP(),
+			})
+		}
+
+		// Same shape as JWT claims so handlers don't care how the request
+		// was authenticated
+		c.Locals("user_id", float64(key.UserID))
+		c.Locals("api_key_id", key.ID)
+		c.Locals("api_key_scopes", key.Scopes)
+
+		return c.Next()
+	}
+}
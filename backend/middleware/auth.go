@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"errors"
 	"os"
 	"strings"
 
@@ -24,9 +25,24 @@ var AuthMiddleware fiber.Handler = func(c *fiber.Ctx) error {
 		})
 	}
 
-	tokenString := parts[1]
+	claims, err := ParseToken(parts[1])
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired token",
+		})
+	}
 
-	// Parse JWT token
+	// Store claims in context
+	c.Locals("user_id", claims["user_id"])
+	c.Locals("email", claims["email"])
+	c.Locals("role_id", claims["role_id"])
+	c.Locals("session_id", claims["sid"])
+
+	return c.Next()
+}
+
+// ParseToken validates a signed access token and returns its claims.
+func ParseToken(tokenString string) (jwt.MapClaims, error) {
 	secret := os.Getenv("JWT_SECRET")
 	if secret == "" {
 		secret = "your-super-secret-jwt-key-change-in-production"
@@ -40,17 +56,12 @@ var AuthMiddleware fiber.Handler = func(c *fiber.Ctx) error {
 	})
 
 	if err != nil || !token.Valid {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid or expired token",
-		})
+		return nil, errors.New("invalid or expired token")
 	}
 
-	// Extract claims and store in context
-	if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		c.Locals("user_id", claims["user_id"])
-		c.Locals("email", claims["email"])
-		c.Locals("role_id", claims["role_id"])
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("invalid token claims")
 	}
-
-	return c.Next()
+	return claims, nil
 }
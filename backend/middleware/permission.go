@@ -0,0 +1,300 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gaming-panel/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// permissionCacheTTL bounds how long a role change can go unnoticed if a
+// cache entry is not invalidated.
+const permissionCacheTTL = 5 * time.Minute
+
+var (
+	ErrUserNotFound          = errors.New("user not found")
+	ErrUserSuspended         = errors.New("account suspended")
+	ErrPasswordResetRequired = errors.New("password reset required")
+	ErrTwoFactorRequired     = errors.New("two-factor enrolment required")
+)
+
+// Authorizer resolves users' role permissions, caching both the user's
+// account state and the role's permissions in Redis.
+type Authorizer struct {
+	db          *gorm.DB
+	redisClient *redis.Client
+}
+
+func NewAuthorizer(db *gorm.DB, redisClient *redis.Client) *Authorizer {
+	return &Authorizer{
+		db:          db,
+		redisClient: redisClient,
+	}
+}
+
+// RequirePermission only lets requests through when the authenticated
+// user's role grants key and, for API keys, the key's scopes include it. It
+// must run after AuthMiddleware or APIKeyAuthMiddleware.
+func (a *Authorizer) RequirePermission(key string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, ok := c.Locals("user_id").(float64)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Authentication required",
+			})
+		}
+
+		if err := a.checkSession(c); err != nil {
+			return sessionError(c, err)
+		}
+
+		permissions, err := a.Permissions(c.Context(), uint(userID))
+		switch {
+		case errors.Is(err, ErrUserNotFound):
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "User no longer exists",
+			})
+		case errors.Is(err, ErrUserSuspended):
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Your account has been suspended",
+			})
+		case errors.Is(err, ErrPasswordResetRequired):
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error":                   "You must change your password before continuing",
+				"password_reset_required": true,
+			})
+		case errors.Is(err, ErrTwoFactorRequired):
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error":                     "Your role requires two-factor authentication; enrol before continuing",
+				"two_factor_setup_required": true,
+			})
+		case err != nil:
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to resolve permissions",
+			})
+		}
+
+		if !permissions.Has(key) {
+			return Forbidden(c, key)
+		}
+
+		// API keys are further limited to their scopes
+		if scopes, ok := c.Locals("api_key_scopes").(models.Permissions); ok && !scopes.Has(key) {
+			return Forbidden(c, key)
+		}
+
+		c.Locals("permissions", permissions)
+		return c.Next()
+	}
+}
+
+// Allowed reports whether the request may also do what key grants, for
+// handlers whose route needs one permission but some requests another. It
+// uses what RequirePermission resolved, so it must run after it.
+func Allowed(c *fiber.Ctx, key string) bool {
+	permissions, ok := c.Locals("permissions").(models.Permissions)
+	if !ok || !permissions.Has(key) {
+		return false
+	}
+	if scopes, ok := c.Locals("api_key_scopes").(models.Permissions); ok && !scopes.Has(key) {
+		return false
+	}
+	return true
+}
+
+// AllowedAll reports whether the request may do everything permissions
+// grants, so nobody can hand out or take over more access than they hold.
+func AllowedAll(c *fiber.Ctx, permissions models.Permissions) bool {
+	for key, granted := range permissions {
+		if granted && !Allowed(c, key) {
+			return false
+		}
+	}
+	return true
+}
+
+// SessionKey is where Redis keeps a login session, i.e. a refresh token
+// family.
+func SessionKey(sessionID string) string {
+	return "refresh:family:" + sessionID
+}
+
+// RequireSession rejects access tokens whose session has been revoked. It
+// must run after AuthMiddleware; RequirePermission does the same check.
+func (a *Authorizer) RequireSession() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := a.checkSession(c); err != nil {
+			return sessionError(c, err)
+		}
+		return c.Next()
+	}
+}
+
+// errSessionEnded means the session an access token belongs to was logged
+// out or revoked.
+var errSessionEnded = errors.New("session ended")
+
+// checkSession returns errSessionEnded unless the session an access token
+// belongs to still exists. API keys have no session. Sessions live only in
+// Redis, so when it can't be asked the request is refused rather than
+// letting a revoked token through.
+func (a *Authorizer) checkSession(c *fiber.Ctx) error {
+	sessionID, ok := c.Locals("session_id").(string)
+	if !ok || sessionID == "" {
+		return nil
+	}
+	exists, err := a.redisClient.Exists(c.Context(), SessionKey(sessionID)).Result()
+	if err != nil {
+		return err
+	}
+	if exists == 0 {
+		return errSessionEnded
+	}
+	return nil
+}
+
+func sessionError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errSessionEnded) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Your session has ended; log in again",
+		})
+	}
+	log.Printf("Failed to check session: %v", err)
+	return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+		"error": "Could not check your session, try again shortly",
+	})
+}
+
+// Forbidden writes the response for a request lacking permission key.
+func Forbidden(c *fiber.Ctx, key string) error {
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error":      "You do not have permission to perform this action",
+		"permission": key,
+	})
+}
+
+// Permissions returns the permissions granted to a user by their role. It
+// fails with ErrUserSuspended, ErrPasswordResetRequired or
+// ErrTwoFactorRequired for users who may not use the panel right now.
+func (a *Authorizer) Permissions(ctx context.Context, userID uint) (models.Permissions, error) {
+	access, err := a.userAccess(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if access.Suspended {
+		return nil, ErrUserSuspended
+	}
+	if access.PasswordResetRequired {
+		return nil, ErrPasswordResetRequired
+	}
+
+	role, err := a.role(ctx, access.RoleID)
+	if err != nil {
+		return nil, err
+	}
+	if role.RequireTwoFactor && !access.TwoFactorEnabled {
+		return nil, ErrTwoFactorRequired
+	}
+	return role.Permissions, nil
+}
+
+// InvalidateUser drops the cached account state of a user after their role,
+// suspension, password reset flag or two-factor enrolment changed.
+func (a *Authorizer) InvalidateUser(ctx context.Context, userID uint) {
+	a.redisClient.Del(ctx, userAccessKey(userID))
+}
+
+// InvalidateRole drops the cached permissions and settings of a role after
+// it changed.
+func (a *Authorizer) InvalidateRole(ctx context.Context, roleID uint) {
+	a.redisClient.Del(ctx, roleKey(roleID))
+}
+
+// userAccess is the part of a user's account that decides what they may do.
+type userAccess struct {
+	RoleID                uint `json:"role_id"`
+	Suspended             bool `json:"suspended"`
+	PasswordResetRequired bool `json:"password_reset_required"`
+	TwoFactorEnabled      bool `json:"two_factor_enabled"`
+}
+
+func (a *Authorizer) userAccess(ctx context.Context, userID uint) (userAccess, error) {
+	var access userAccess
+	if cached, err := a.redisClient.Get(ctx, userAccessKey(userID)).Bytes(); err == nil {
+		if json.Unmarshal(cached, &access) == nil {
+			return access, nil
+		}
+	}
+
+	var user models.User
+	if err := a.db.WithContext(ctx).Select("id", "role_id", "suspended_at", "password_reset_required", "totp_enabled_at").First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return access, ErrUserNotFound
+		}
+		return access, err
+	}
+
+	access = userAccess{
+		RoleID:                user.RoleID,
+		Suspended:             user.SuspendedAt != nil,
+		PasswordResetRequired: user.PasswordResetRequired,
+		TwoFactorEnabled:      user.TOTPEnabledAt != nil,
+	}
+	if encoded, err := json.Marshal(access); err == nil {
+		a.redisClient.Set(ctx, userAccessKey(userID), encoded, permissionCacheTTL)
+	}
+	return access, nil
+}
+
+// roleAccess is the part of a role the authorizer needs.
+type roleAccess struct {
+	Permissions      models.Permissions `json:"permissions"`
+	RequireTwoFactor bool               `json:"require_two_factor"`
+}
+
+func (a *Authorizer) role(ctx context.Context, roleID uint) (roleAccess, error) {
+	var access roleAccess
+	if cached, err := a.redisClient.Get(ctx, roleKey(roleID)).Bytes(); err == nil {
+		if json.Unmarshal(cached, &access) == nil {
+			return access, nil
+		}
+	}
+
+	var role models.Role
+	if err := a.db.WithContext(ctx).First(&role, roleID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// A user whose role was removed has no permissions
+			return roleAccess{Permissions: models.Permissions{}}, nil
+		}
+		return access, err
+	}
+
+	access = roleAccess{
+		Permissions:      role.Permissions,
+		RequireTwoFactor: role.RequireTwoFactor,
+	}
+	if access.Permissions == nil {
+		access.Permissions = models.Permissions{}
+	}
+
+	if encoded, err := json.Marshal(access); err == nil {
+		a.redisClient.Set(ctx, roleKey(roleID), encoded, permissionCacheTTL)
+	}
+	return access, nil
+}
+
+func userAccessKey(userID uint) string {
+	return fmt.Sprintf("user:%d:access", userID)
+}
+
+func roleKey(roleID uint) string {
+	return fmt.Sprintf("role:%d:access", roleID)
+}
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// Limit allows Max requests per sliding Window. Name keeps the counters of
+// different limits apart.
+type Limit struct {
+	Name   string
+	Max    int
+	Window time.Duration
+}
+
+// RateLimitResult is the outcome of counting one request against a Limit.
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	Reset     time.Duration // until the oldest counted request leaves the window
+}
+
+// slidingWindow keeps one sorted set member per request, scored by its time
+// in milliseconds. A request is only recorded if it is allowed, so clients
+// that keep retrying while limited are let back in once the window passes.
+var slidingWindow = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local max = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if count < max then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', KEYS[1], window)
+
+local reset = window
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, count, reset}
+`)
+
+func rateLimitKey(limit Limit, id string) string {
+	return "ratelimit:" + limit.Name + ":" + id
+}
+
+// Allow counts a request by id against limit.
+func Allow(ctx context.Context, redisClient *redis.Client, limit Limit, id string) (RateLimitResult, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return RateLimitResult{}, err
+	}
+
+	now := time.Now().UnixMilli()
+	values, err := slidingWindow.Run(ctx, redisClient, []string{rateLimitKey(limit, id)},
+		now, limit.Window.Milliseconds(), limit.Max, fmt.Sprintf("%d-%s", now, hex.EncodeToString(buf)),
+	).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	return RateLimitResult{
+		Allowed:   values[0] == 1,
+		Remaining: limit.Max - int(values[1]),
+		Reset:     time.Duration(values[2]) * time.Millisecond,
+	}, nil
+}
+
+// ApplyRateLimit counts the request against limit and sets the
+// X-RateLimit-* headers. When Redis is unavailable the request is allowed.
+func ApplyRateLimit(c *fiber.Ctx, redisClient *redis.Client, limit Limit, id string) RateLimitResult {
+	result, err := Allow(c.Context(), redisClient, limit, id)
+	if err != nil {
+		log.Printf("Rate limit %s unavailable: %v", limit.Name, err)
+		return RateLimitResult{Allowed: true, Remaining: limit.Max}
+	}
+
+	c.Set("X-RateLimit-Limit", strconv.Itoa(limit.Max))
+	c.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	return result
+}
+
+// TooManyRequests is the response for a limited request.
+func TooManyRequests(c *fiber.Ctx, retryAfter time.Duration, message string) error {
+	seconds := ceilSeconds(retryAfter)
+	c.Set("Retry-After", strconv.Itoa(seconds))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":       message,
+		"retry_after": seconds,
+	})
+}
+
+// RateLimit limits requests by the id key returns for them.
+func RateLimit(redisClient *redis.Client, limit Limit, key func(c *fiber.Ctx) string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if result := ApplyRateLimit(c, redisClient, limit, key(c)); !result.Allowed {
+			return TooManyRequests(c, result.Reset, "Too many requests, please slow down")
+		}
+		return c.Next()
+	}
+}
+
+// ByIP keys rate limits by client IP.
+func ByIP(c *fiber.Ctx) string {
+	return c.IP()
+}
+
+// ByUser keys rate limits by the authenticated user.
+func ByUser(c *fiber.Ctx) string {
+	return strconv.Itoa(int(c.Locals("user_id").(float64)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -11,7 +11,7 @@ type Allocation struct {
 	NodeID    uint           `json:"node_id" gorm:"not null;index"`
 	Node      Node           `json:"node,omitempty" gorm:"foreignKey:NodeID"`
 	IP        string         `json:"ip" gorm:"not null"`
-	Port      int            `json:"port" gorm:"not null;uniqueIndex:idx_allocation"`
+	Port      int            `json:"port" gorm:"not null;uniqueIndex:idx_allocation,where:deleted_at IS NULL"`
 	Assigned  bool           `json:"assigned" gorm:"default:false"`
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
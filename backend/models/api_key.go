@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type APIKeyType string
+
+const (
+	// APIKeyTypePersonal keys act on the owner's own servers
+	APIKeyTypePersonal APIKeyType = "personal"
+	// APIKeyTypeApplication keys may also use admin endpoints
+	APIKeyTypeApplication APIKeyType = "application"
+)
+
+// APIKey is a long-lived credential for automation. Only a SHA-256 hash of
+// the key is stored; the key itself is shown once when it is created.
+type APIKey struct {
+	ID         uint           `json:"id" gorm:"primaryKey"`
+	UserID     uint           `json:"user_id" gorm:"not null;index"`
+	User       *User          `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Name       string         `json:"name" gorm:"not null"`
+	Type       APIKeyType     `json:"type" gorm:"not null;default:'personal'"`
+	Prefix     string         `json:"prefix" gorm:"not null"` // first characters of the key, to tell keys apart
+	KeyHash    string         `json:"-" gorm:"uniqueIndex;not null"`
+	Scopes     Permissions    `json:"scopes" gorm:"type:jsonb"`                      // permission keys the key may use
+	AllowedIPs []string       `json:"allowed_ips" gorm:"serializer:json;type:jsonb"` // IPs or CIDRs; empty allows any
+	ExpiresAt  *time.Time     `json:"expires_at"`
+	LastUsedAt *time.Time     `json:"last_used_at"`
+	LastUsedIP string         `json:"last_used_ip"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `json:"-" gorm:"index"`
+}
@@ -2,20 +2,22 @@ package models
 
 import (
 	"time"
-
-	"gorm.io/gorm"
 )
 
+// AuditLog entries form a hash chain: Hash covers the entry and PrevHash,
+// the Hash of the entry before it, so changing or removing an entry breaks
+// every link after it. Entries cannot be updated or deleted.
 type AuditLog struct {
-	ID          uint           `json:"id" gorm:"primaryKey"`
-	UserID      *uint          `json:"user_id"`
-	User        *User          `json:"user,omitempty" gorm:"foreignKey:UserID"`
-	Action      string         `json:"action" gorm:"not null"` // e.g., "server.start", "server.stop"
-	ResourceType string        `json:"resource_type"`           // e.g., "server", "node"
-	ResourceID  *uint          `json:"resource_id"`
-	IP          string         `json:"ip"`
-	UserAgent   string         `json:"user_agent"`
-	Metadata    map[string]interface{} `json:"metadata" gorm:"type:jsonb"`
-	CreatedAt   time.Time      `json:"created_at"`
-	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+	ID           uint                   `json:"id" gorm:"primaryKey"`
+	UserID       *uint                  `json:"user_id" gorm:"index"`
+	User         *User                  `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Action       string                 `json:"action" gorm:"not null"`                             // e.g., "server.start", "server.stop"
+	ResourceType string                 `json:"resource_type" gorm:"index:idx_audit_logs_resource"` // e.g., "server", "node"
+	ResourceID   *uint                  `json:"resource_id" gorm:"index:idx_audit_logs_resource"`
+	IP           string                 `json:"ip"`
+	UserAgent    string                 `json:"user_agent"`
+	Metadata     map[string]interface{} `json:"metadata" gorm:"serializer:json;type:jsonb"`
+	CreatedAt    time.Time              `json:"created_at" gorm:"index"`
+	PrevHash     string                 `json:"prev_hash" gorm:"not null;default:''"` // hex SHA-256, empty for the first entry
+	Hash         string                 `json:"hash" gorm:"not null;default:''"`
 }
@@ -7,13 +7,16 @@ import (
 )
 
 type Backup struct {
-	ID        uint           `json:"id" gorm:"primaryKey"`
-	ServerID  uint           `json:"server_id" gorm:"not null;index"`
-	Server    Server         `json:"server,omitempty" gorm:"foreignKey:ServerID"`
-	Size      int64          `json:"size"` // bytes
-	Path      string         `json:"path" gorm:"not null"`
-	IsSuccess bool           `json:"is_success" gorm:"default:false"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	ID          uint           `json:"id" gorm:"primaryKey"`
+	ServerID    uint           `json:"server_id" gorm:"not null;index"`
+	Server      Server         `json:"server,omitempty" gorm:"foreignKey:ServerID"`
+	Size        int64          `json:"size"` // bytes
+	Path        string         `json:"path" gorm:"not null"`
+	Checksum    string         `json:"checksum"` // SHA-256 of the archive
+	IsSuccess   bool           `json:"is_success" gorm:"default:false"`
+	Error       string         `json:"error,omitempty"`
+	CompletedAt *time.Time     `json:"completed_at"` // nil while the daemon is working
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
 }
@@ -14,21 +14,37 @@ const (
 )
 
 type Node struct {
-	ID          uint         `json:"id" gorm:"primaryKey"`
-	Name        string       `json:"name" gorm:"not null"`
-	Hostname    string       `json:"hostname" gorm:"not null"`
-	IP          string       `json:"ip" gorm:"not null"`
-	Port        int          `json:"port" gorm:"default:8080"`
-	TotalRAM    int64        `json:"total_ram"`    // bytes
-	TotalCPU    int64        `json:"total_cpu"`    // nano CPUs
-	TotalDisk   int64        `json:"total_disk"`   // bytes
-	UsedRAM     int64        `json:"used_ram"`     // bytes
-	UsedCPU     int64        `json:"used_cpu"`     // nano CPUs
-	UsedDisk    int64        `json:"used_disk"`    // bytes
-	Status      NodeStatus   `json:"status" gorm:"default:'offline'"`
-	Servers     []Server     `json:"servers,omitempty"`
-	Allocations []Allocation `json:"allocations,omitempty"`
-	CreatedAt   time.Time    `json:"created_at"`
-	UpdatedAt   time.Time    `json:"updated_at"`
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	Name        string     `json:"name" gorm:"not null"`
+	Hostname    string     `json:"hostname" gorm:"not null"`
+	IP          string     `json:"ip" gorm:"not null"`
+	Port        int        `json:"port" gorm:"default:8080"`
+	Scheme      string     `json:"scheme" gorm:"not null;default:https"`
+	TotalRAM    int64      `json:"total_ram"`  // bytes
+	TotalCPU    int64      `json:"total_cpu"`  // nano CPUs
+	TotalDisk   int64      `json:"total_disk"` // bytes
+	UsedRAM     int64      `json:"used_ram"`   // bytes
+	UsedCPU     int64      `json:"used_cpu"`   // nano CPUs
+	UsedDisk    int64      `json:"used_disk"`  // bytes of server data
+	Status      NodeStatus `json:"status" gorm:"default:'offline'"`
+	Maintenance bool       `json:"maintenance" gorm:"not null;default:false"` // refuse new work for the node's servers
+
+	// Reported by the daemon's heartbeats, which also keep the totals and
+	// usage up to date
+	LastHeartbeatAt *time.Time `json:"last_heartbeat_at"`
+	DaemonVersion   string     `json:"daemon_version"`
+	DockerVersion   string     `json:"docker_version"`
+
+	// The daemon authenticates with a long-lived token it gets by
+	// exchanging the one-time enrollment token issued by an admin
+	TokenHash           string     `json:"-" gorm:"index"`
+	EnrollmentTokenHash string     `json:"-" gorm:"index"`
+	EnrollmentExpiresAt *time.Time `json:"enrollment_expires_at"`
+	EnrolledAt          *time.Time `json:"enrolled_at"`
+
+	Servers     []Server       `json:"servers,omitempty"`
+	Allocations []Allocation   `json:"allocations,omitempty"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
 }
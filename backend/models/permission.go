@@ -0,0 +1,85 @@
+package models
+
+// Permission keys checked by middleware.RequirePermission. A role grants a
+// permission by setting its key to true in Role.Permissions; the wildcard
+// PermissionAll grants every permission.
+const (
+	PermissionAll = "*"
+
+	PermissionServerView   = "server.view"   // list and view own servers, their status and backups
+	PermissionServerManage = "server.manage" // create, delete, power, console and backups for own servers
+
+	PermissionNodeView   = "node.view"   // list and view nodes
+	PermissionNodeCreate = "node.create" // register new nodes
+	PermissionNodeManage = "node.manage" // update, delete and put nodes into maintenance
+
+	PermissionRoleView   = "role.view"   // list roles and their members
+	PermissionRoleManage = "role.manage" // create, update and delete roles and assign them to users
+
+	PermissionUserView   = "user.view"   // list and search users
+	PermissionUserManage = "user.manage" // create, update, suspend and delete users
+
+	PermissionAdminMetrics = "admin.metrics" // panel-wide server and node counts
+	PermissionAuditView    = "audit.view"    // search and export the panel-wide audit log
+
+	PermissionAPIApplication = "api.application" // create application API keys
+)
+
+// PermissionCatalogue documents every permission key a role can grant.
+var PermissionCatalogue = map[string]string{
+	PermissionServerView:     "List and view your servers, their status and backups",
+	PermissionServerManage:   "Create and delete your servers, control power, send console commands and manage backups",
+	PermissionNodeView:       "List and view nodes",
+	PermissionNodeCreate:     "Register new nodes",
+	PermissionNodeManage:     "Update and delete nodes, put them into maintenance and reissue enrollment tokens",
+	PermissionRoleView:       "List roles and their members",
+	PermissionRoleManage:     "Create, update and delete roles and assign them to users",
+	PermissionUserView:       "List and search users",
+	PermissionUserManage:     "Create, update, suspend, force password resets for and delete users",
+	PermissionAdminMetrics:   "View panel-wide server and node metrics",
+	PermissionAuditView:      "Search and export the panel-wide audit log",
+	PermissionAPIApplication: "Create application API keys, which can use admin endpoints",
+}
+
+// PersonalAPIKeyScopes are the permissions a personal API key may carry.
+// Application keys may carry any permission their creator holds.
+var PersonalAPIKeyScopes = Permissions{
+	PermissionServerView:   true,
+	PermissionServerManage: true,
+}
+
+// Per-server permission keys granted to subusers in Subuser.Permissions.
+// Owners hold all of them on their own servers.
+const (
+	ServerPermissionConsole      = "control.console" // watch the console and send commands
+	ServerPermissionPower        = "control.power"   // start, stop and restart
+	ServerPermissionBackupView   = "backup.view"     // list and download backups
+	ServerPermissionBackupManage = "backup.manage"   // create, restore and delete backups
+)
+
+// ServerPermissionCatalogue documents every permission key a subuser can be
+// granted.
+var ServerPermissionCatalogue = map[string]string{
+	ServerPermissionConsole:      "Watch the console and send commands",
+	ServerPermissionPower:        "Start, stop and restart the server",
+	ServerPermissionBackupView:   "List and download backups",
+	ServerPermissionBackupManage: "Create, restore and delete backups",
+}
+
+// Built-in roles seeded on startup.
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// DefaultUserPermissions are granted to the built-in user role when it is
+// first created.
+var DefaultUserPermissions = Permissions{
+	PermissionServerView:   true,
+	PermissionServerManage: true,
+}
+
+// Has reports whether the permissions grant key.
+func (p Permissions) Has(key string) bool {
+	return p[PermissionAll] || p[key]
+}
@@ -27,11 +27,12 @@ func (p *Permissions) Scan(value interface{}) error {
 }
 
 type Role struct {
-	ID          uint           `json:"id" gorm:"primaryKey"`
-	Name        string         `json:"name" gorm:"uniqueIndex;not null"`
-	Permissions Permissions    `json:"permissions" gorm:"type:jsonb"`
-	Users       []User         `json:"users,omitempty"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+	ID               uint           `json:"id" gorm:"primaryKey"`
+	Name             string         `json:"name" gorm:"uniqueIndex;not null"`
+	Permissions      Permissions    `json:"permissions" gorm:"type:jsonb"`
+	RequireTwoFactor bool           `json:"require_two_factor"` // members must enrol in TOTP before using the panel
+	Users            []User         `json:"users,omitempty"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `json:"-" gorm:"index"`
 }
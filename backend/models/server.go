@@ -16,24 +16,35 @@ const (
 	ServerStatusStopping ServerStatus = "stopping"
 )
 
+// Valid reports whether s is one of the known server statuses.
+func (s ServerStatus) Valid() bool {
+	switch s {
+	case ServerStatusOffline, ServerStatusStarting, ServerStatusOnline, ServerStatusStopping:
+		return true
+	}
+	return false
+}
+
 type Server struct {
-	ID           uint         `json:"id" gorm:"primaryKey"`
-	UUID         string       `json:"uuid" gorm:"uniqueIndex;not null"`
-	Name         string       `json:"name" gorm:"not null"`
-	OwnerID      uint         `json:"owner_id" gorm:"not null;index"`
-	Owner        User         `json:"owner,omitempty" gorm:"foreignKey:OwnerID"`
-	NodeID       uint         `json:"node_id" gorm:"not null;index"`
-	Node         Node         `json:"node,omitempty" gorm:"foreignKey:NodeID"`
-	AllocationID uint         `json:"allocation_id" gorm:"not null;index"`
-	Allocation   Allocation   `json:"allocation,omitempty" gorm:"foreignKey:AllocationID"`
-	DockerImage  string       `json:"docker_image" gorm:"not null"`
-	Status       ServerStatus `json:"status" gorm:"default:'offline'"`
-	MemoryLimit  int64        `json:"memory_limit"` // bytes
-	CPULimit     int64        `json:"cpu_limit"`    // nano CPUs
-	DiskLimit    int64        `json:"disk_limit"`   // bytes
-	Backups      []Backup     `json:"backups,omitempty"`
-	CreatedAt    time.Time    `json:"created_at"`
-	UpdatedAt    time.Time    `json:"updated_at"`
+	ID           uint           `json:"id" gorm:"primaryKey"`
+	UUID         string         `json:"uuid" gorm:"uniqueIndex;not null"`
+	Name         string         `json:"name" gorm:"not null"`
+	OwnerID      uint           `json:"owner_id" gorm:"not null;index"`
+	Owner        User           `json:"owner,omitempty" gorm:"foreignKey:OwnerID"`
+	NodeID       uint           `json:"node_id" gorm:"not null;index"`
+	Node         Node           `json:"node,omitempty" gorm:"foreignKey:NodeID"`
+	AllocationID uint           `json:"allocation_id" gorm:"not null;index"`
+	Allocation   Allocation     `json:"allocation,omitempty" gorm:"foreignKey:AllocationID"`
+	DockerImage  string         `json:"docker_image" gorm:"not null"`
+	Status       ServerStatus   `json:"status" gorm:"default:'offline'"`
+	StatusError  string         `json:"status_error,omitempty"` // why the last power action failed
+	StatusAt     *time.Time     `json:"-"`                      // when the daemon reported the status
+	MemoryLimit  int64          `json:"memory_limit"`           // bytes
+	CPULimit     int64          `json:"cpu_limit"`              // nano CPUs
+	DiskLimit    int64          `json:"disk_limit"`             // bytes
+	Backups      []Backup       `json:"backups,omitempty"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
 	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
@@ -43,4 +54,3 @@ func (s *Server) BeforeCreate(tx *gorm.DB) error {
 	}
 	return nil
 }
-
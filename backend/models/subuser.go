@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Subuser gives another account access to a server with a subset of the
+// owner's rights. It starts out as an invite for Email and is bound to a user
+// once they accept it.
+type Subuser struct {
+	ID              uint           `json:"id" gorm:"primaryKey"`
+	ServerID        uint           `json:"server_id" gorm:"not null;index"`
+	Server          *Server        `json:"server,omitempty" gorm:"foreignKey:ServerID"`
+	UserID          *uint          `json:"user_id" gorm:"index"` // nil until the invite is accepted
+	User            *User          `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Email           string         `json:"email" gorm:"not null;index"`
+	Permissions     Permissions    `json:"permissions" gorm:"type:jsonb"` // ServerPermission* keys
+	InvitedByID     uint           `json:"invited_by_id"`
+	InviteTokenHash string         `json:"-" gorm:"index"` // SHA-256 of the invite token
+	ExpiresAt       time.Time      `json:"expires_at"`     // when an unaccepted invite lapses
+	AcceptedAt      *time.Time     `json:"accepted_at"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	DeletedAt       gorm.DeletedAt `json:"-" gorm:"index"`
+}
@@ -6,14 +6,23 @@ import (
 	"gorm.io/gorm"
 )
 
+// MinPasswordLength is the shortest password accepted for new passwords.
+const MinPasswordLength = 8
+
 type User struct {
-	ID           uint           `json:"id" gorm:"primaryKey"`
-	Email        string         `json:"email" gorm:"uniqueIndex;not null"`
-	Username     string         `json:"username" gorm:"uniqueIndex;not null"`
-	PasswordHash string         `json:"-" gorm:"not null"`
-	RoleID       uint           `json:"role_id" gorm:"not null"`
-	Role         Role           `json:"role,omitempty" gorm:"foreignKey:RoleID"`
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
-	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
+	ID                    uint           `json:"id" gorm:"primaryKey"`
+	Email                 string         `json:"email" gorm:"uniqueIndex;not null"`
+	EmailVerifiedAt       *time.Time     `json:"email_verified_at"`
+	Username              string         `json:"username" gorm:"uniqueIndex;not null"`
+	PasswordHash          string         `json:"-" gorm:"not null"`
+	RoleID                uint           `json:"role_id" gorm:"not null"`
+	Role                  Role           `json:"role,omitempty" gorm:"foreignKey:RoleID"`
+	SuspendedAt           *time.Time     `json:"suspended_at"`
+	LastLoginAt           *time.Time     `json:"last_login_at"`
+	PasswordResetRequired bool           `json:"password_reset_required"` // blocks the account until the password is changed
+	TOTPSecret            string         `json:"-"`                       // base32, set once two-factor is confirmed
+	TOTPEnabledAt         *time.Time     `json:"totp_enabled_at"`
+	CreatedAt             time.Time      `json:"created_at"`
+	UpdatedAt             time.Time      `json:"updated_at"`
+	DeletedAt             gorm.DeletedAt `json:"-" gorm:"index"`
 }
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// UserIdentity links a user to their account at an OIDC provider, which is
+// identified by the issuer and the subject it assigns.
+type UserIdentity struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	UserID      uint       `json:"user_id" gorm:"not null;index"`
+	Issuer      string     `json:"issuer" gorm:"not null;uniqueIndex:idx_user_identities_subject"`
+	Subject     string     `json:"subject" gorm:"not null;uniqueIndex:idx_user_identities_subject"`
+	Email       string     `json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
@@ -1,7 +1,9 @@
 package admin
 
 import (
+	"gaming-panel/backend/audit"
 	"gaming-panel/backend/config"
+	"gaming-panel/backend/middleware"
 	"gaming-panel/backend/models"
 
 	"github.com/gofiber/fiber/v2"
@@ -9,11 +11,39 @@ import (
 	"gorm.io/gorm"
 )
 
-func SetupAdminRoutes(router fiber.Router, db *gorm.DB, redisClient *redis.Client, cfg *config.Config) {
-	// TODO: Add admin middleware for role check
-	router.Get("/metrics", getMetrics(db, redisClient))
-	router.Get("/roles", listRoles(db))
-	router.Post("/nodes", createNode(db))
+func SetupAdminRoutes(router fiber.Router, db *gorm.DB, redisClient *redis.Client, authz *middleware.Authorizer, auditor *audit.Logger, cfg *config.Config) {
+	router.Get("/metrics", authz.RequirePermission(models.PermissionAdminMetrics), getMetrics(db, redisClient))
+	viewAudit := authz.RequirePermission(models.PermissionAuditView)
+	router.Get("/audit-logs", viewAudit, listAuditLogs(db))
+	router.Get("/audit-logs/verify", viewAudit, verifyAuditLog(db))
+
+	viewRoles := authz.RequirePermission(models.PermissionRoleView)
+	manageRoles := authz.RequirePermission(models.PermissionRoleManage)
+	router.Get("/permissions", viewRoles, listPermissions())
+	router.Get("/roles", viewRoles, listRoles(db))
+	router.Get("/roles/:id", viewRoles, getRole(db))
+	router.Post("/roles", manageRoles, createRole(db, auditor))
+	router.Put("/roles/:id", manageRoles, updateRole(db, authz, auditor))
+	router.Delete("/roles/:id", manageRoles, deleteRole(db, authz, auditor))
+	router.Put("/users/:id/role", manageRoles, assignUserRole(db, authz, auditor))
+
+	viewUsers := authz.RequirePermission(models.PermissionUserView)
+	manageUsers := authz.RequirePermission(models.PermissionUserManage)
+	router.Get("/users", viewUsers, listUsers(db))
+	router.Get("/users/:id", viewUsers, getUser(db))
+	router.Post("/users", manageUsers, createUser(db, auditor))
+	router.Put("/users/:id", manageUsers, updateUser(db, authz, auditor))
+	router.Post("/users/:id/suspend", manageUsers, setUserSuspended(db, redisClient, authz, auditor, true))
+	router.Post("/users/:id/unsuspend", manageUsers, setUserSuspended(db, redisClient, authz, auditor, false))
+	router.Post("/users/:id/password-reset", manageUsers, forcePasswordReset(db, authz, auditor))
+	router.Post("/users/:id/2fa/reset", manageUsers, resetTwoFactor(db, authz, auditor))
+	router.Delete("/users/:id", manageUsers, deleteUser(db, redisClient, authz, auditor))
+
+	manageNodes := authz.RequirePermission(models.PermissionNodeManage)
+	router.Post("/nodes", authz.RequirePermission(models.PermissionNodeCreate), createNode(db, auditor))
+	router.Put("/nodes/:id", manageNodes, updateNode(db, auditor))
+	router.Post("/nodes/:id/enrollment-token", manageNodes, reissueEnrollmentToken(db, auditor))
+	router.Delete("/nodes/:id", manageNodes, deleteNode(db, auditor))
 }
 
 func getMetrics(db *gorm.DB, redisClient *redis.Client) fiber.Handler {
@@ -53,42 +83,3 @@ func listRoles(db *gorm.DB) fiber.Handler {
 		return c.JSON(roles)
 	}
 }
-
-func createNode(db *gorm.DB) fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		var req struct {
-			Name      string `json:"name"`
-			Hostname  string `json:"hostname"`
-			IP        string `json:"ip"`
-			Port      int    `json:"port"`
-			TotalRAM  int64  `json:"total_ram"`
-			TotalCPU  int64  `json:"total_cpu"`
-			TotalDisk int64  `json:"total_disk"`
-		}
-
-		if err := c.BodyParser(&req); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid request body",
-			})
-		}
-
-		node := models.Node{
-			Name:      req.Name,
-			Hostname:  req.Hostname,
-			IP:        req.IP,
-			Port:      req.Port,
-			TotalRAM:  req.TotalRAM,
-			TotalCPU:  req.TotalCPU,
-			TotalDisk: req.TotalDisk,
-			Status:    models.NodeStatusOffline,
-		}
-
-		if err := db.Create(&node).Error; err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to create node",
-			})
-		}
-
-		return c.Status(fiber.StatusCreated).JSON(node)
-	}
-}
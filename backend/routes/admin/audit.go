@@ -0,0 +1,37 @@
+package admin
+
+import (
+	"gaming-panel/backend/audit"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// listAuditLogs searches the whole audit log, or exports it with ?format=.
+func listAuditLogs(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		filter, err := audit.ParseFilter(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return audit.Serve(c, db, filter, "audit-log")
+	}
+}
+
+// verifyAuditLog checks the audit log's hash chain and reports the first
+// broken link.
+func verifyAuditLog(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		result, err := audit.Verify(c.Context(), db)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to verify audit log",
+			})
+		}
+
+		return c.JSON(result)
+	}
+}
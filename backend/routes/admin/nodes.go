@@ -0,0 +1,292 @@
+package admin
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gaming-panel/backend/audit"
+	"gaming-panel/backend/middleware"
+	"gaming-panel/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// enrollmentTTL is how long a node's enrollment token can be exchanged.
+const enrollmentTTL = 24 * time.Hour
+
+// validScheme reports whether the daemon's HTTP server can be reached with
+// scheme. Plain http sends download links in the clear, so https is the
+// default.
+func validScheme(scheme string) bool {
+	return scheme == "http" || scheme == "https"
+}
+
+// issueEnrollmentToken gives node a new enrollment token, replacing any
+// unused one. The token is returned once and only its hash is stored.
+func issueEnrollmentToken(node *models.Node) (string, error) {
+	token, err := middleware.NewNodeToken(middleware.EnrollmentTokenPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := time.Now().Add(enrollmentTTL)
+	node.EnrollmentTokenHash = middleware.HashAPIKey(token)
+	node.EnrollmentExpiresAt = &expiresAt
+	return token, nil
+}
+
+// createNode registers a node. The response carries the enrollment token the
+// node's daemon needs to authenticate; it is not shown again.
+func createNode(db *gorm.DB, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			Name      string `json:"name"`
+			Hostname  string `json:"hostname"`
+			IP        string `json:"ip"`
+			Port      int    `json:"port"`
+			Scheme    string `json:"scheme"`
+			TotalRAM  int64  `json:"total_ram"`
+			TotalCPU  int64  `json:"total_cpu"`
+			TotalDisk int64  `json:"total_disk"`
+		}
+
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		if req.Scheme == "" {
+			req.Scheme = "https"
+		}
+		if !validScheme(req.Scheme) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "scheme must be http or https",
+			})
+		}
+
+		node := models.Node{
+			Name:      req.Name,
+			Hostname:  req.Hostname,
+			IP:        req.IP,
+			Port:      req.Port,
+			Scheme:    req.Scheme,
+			TotalRAM:  req.TotalRAM,
+			TotalCPU:  req.TotalCPU,
+			TotalDisk: req.TotalDisk,
+			Status:    models.NodeStatusOffline,
+		}
+
+		token, err := issueEnrollmentToken(&node)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create node",
+			})
+		}
+
+		if err := db.Create(&node).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create node",
+			})
+		}
+
+		auditor.Record(c, "node.create", "node", &node.ID, map[string]interface{}{
+			"name":     node.Name,
+			"hostname": node.Hostname,
+			"ip":       node.IP,
+			"port":     node.Port,
+			"scheme":   node.Scheme,
+		})
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"node":             node,
+			"enrollment_token": token,
+		})
+	}
+}
+
+// updateNode changes the fields present in the request. Putting a node into
+// maintenance stops the panel from sending its daemon new work; servers
+// already running keep running. Totals are not editable, the daemon
+// reports them.
+func updateNode(db *gorm.DB, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			Name        *string `json:"name"`
+			Hostname    *string `json:"hostname"`
+			IP          *string `json:"ip"`
+			Port        *int    `json:"port"`
+			Scheme      *string `json:"scheme"`
+			Maintenance *bool   `json:"maintenance"`
+		}
+
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		var node models.Node
+		if err := db.First(&node, c.Params("id")).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Node not found",
+			})
+		}
+
+		if req.Port != nil && (*req.Port < 1 || *req.Port > 65535) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "port must be between 1 and 65535",
+			})
+		}
+		if req.Scheme != nil && !validScheme(*req.Scheme) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "scheme must be http or https",
+			})
+		}
+		for _, field := range []*string{req.Name, req.Hostname, req.IP} {
+			if field != nil {
+				*field = strings.TrimSpace(*field)
+				if *field == "" {
+					return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+						"error": "name, hostname and ip cannot be empty",
+					})
+				}
+			}
+		}
+
+		updates := map[string]interface{}{}
+		changes := map[string]interface{}{}
+		set := func(column string, from, to interface{}) {
+			if from != to {
+				updates[column] = to
+				changes[column] = fiber.Map{"from": from, "to": to}
+			}
+		}
+		if req.Name != nil {
+			set("name", node.Name, *req.Name)
+		}
+		if req.Hostname != nil {
+			set("hostname", node.Hostname, *req.Hostname)
+		}
+		if req.IP != nil {
+			set("ip", node.IP, *req.IP)
+		}
+		if req.Port != nil {
+			set("port", node.Port, *req.Port)
+		}
+		if req.Scheme != nil {
+			set("scheme", node.Scheme, *req.Scheme)
+		}
+		if req.Maintenance != nil {
+			set("maintenance", node.Maintenance, *req.Maintenance)
+		}
+
+		if len(updates) > 0 {
+			if err := db.Model(&node).Updates(updates).Error; err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to update node",
+				})
+			}
+			auditor.Record(c, "node.update", "node", &node.ID, changes)
+			db.First(&node, node.ID)
+		}
+
+		return c.JSON(node)
+	}
+}
+
+// reissueEnrollmentToken lets a node enroll again, e.g. after its daemon was
+// reinstalled. The current node token keeps working until the new
+// enrollment token is exchanged.
+func reissueEnrollmentToken(db *gorm.DB, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var node models.Node
+		if err := db.First(&node, c.Params("id")).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Node not found",
+			})
+		}
+
+		token, err := issueEnrollmentToken(&node)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to issue enrollment token",
+			})
+		}
+
+		if err := db.Model(&node).Updates(map[string]interface{}{
+			"enrollment_token_hash": node.EnrollmentTokenHash,
+			"enrollment_expires_at": node.EnrollmentExpiresAt,
+		}).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to issue enrollment token",
+			})
+		}
+
+		auditor.Record(c, "node.enrollment_token", "node", &node.ID, nil)
+
+		return c.JSON(fiber.Map{
+			"enrollment_token": token,
+			"expires_at":       node.EnrollmentExpiresAt,
+		})
+	}
+}
+
+// deleteNode removes a node and its allocations. Nodes that still have
+// servers are refused; move or delete the servers first.
+func deleteNode(db *gorm.DB, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var node models.Node
+		if err := db.First(&node, c.Params("id")).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Node not found",
+			})
+		}
+
+		var servers int64
+		db.Model(&models.Server{}).Where("node_id = ?", node.ID).Count(&servers)
+		if servers > 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": fmt.Sprintf("Node still has %d server(s)", servers),
+			})
+		}
+
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			// Deleted servers still reference their allocations, so those
+			// are only soft-deleted; the rest are removed outright. Ports are
+			// unique among live allocations only, so a replacement node can
+			// reuse them either way.
+			referenced := tx.Session(&gorm.Session{NewDB: true}).Unscoped().
+				Model(&models.Server{}).Select("allocation_id")
+			if err := tx.Unscoped().Where("node_id = ? AND id NOT IN (?)", node.ID, referenced).Delete(&models.Allocation{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("node_id = ?", node.ID).Delete(&models.Allocation{}).Error; err != nil {
+				return err
+			}
+			// Revoke the daemon's credentials along with the node
+			if err := tx.Model(&node).Updates(map[string]interface{}{
+				"token_hash":            "",
+				"enrollment_token_hash": "",
+			}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&node).Error
+		}); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete node",
+			})
+		}
+
+		auditor.Record(c, "node.delete", "node", &node.ID, map[string]interface{}{
+			"name":     node.Name,
+			"hostname": node.Hostname,
+		})
+
+		return c.JSON(fiber.Map{
+			"message": "Node deleted",
+		})
+	}
+}
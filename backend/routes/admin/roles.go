@@ -0,0 +1,379 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gaming-panel/backend/audit"
+	"gaming-panel/backend/middleware"
+	"gaming-panel/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+func isBuiltInRole(name string) bool {
+	return name == models.RoleAdmin || name == models.RoleUser
+}
+
+// validatePermissions checks every key against the catalogue and drops the
+// ones that are not granted.
+func validatePermissions(permissions models.Permissions) (models.Permissions, error) {
+	granted := models.Permissions{}
+	for key, value := range permissions {
+		if _, ok := models.PermissionCatalogue[key]; !ok && key != models.PermissionAll {
+			return nil, fmt.Errorf("unknown permission %q", key)
+		}
+		if value {
+			granted[key] = true
+		}
+	}
+	return granted, nil
+}
+
+// roleTooPowerful answers requests to grant, edit or assign a role with
+// permissions the actor doesn't hold.
+func roleTooPowerful(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error": "You cannot grant or change a role with permissions you don't have",
+	})
+}
+
+func listPermissions() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(models.PermissionCatalogue)
+	}
+}
+
+func getRole(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var role models.Role
+		if err := db.Preload("Users").First(&role, c.Params("id")).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Role not found",
+			})
+		}
+
+		return c.JSON(role)
+	}
+}
+
+func createRole(db *gorm.DB, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			Name             string             `json:"name"`
+			Permissions      models.Permissions `json:"permissions"`
+			RequireTwoFactor bool               `json:"require_two_factor"`
+		}
+
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		req.Name = strings.TrimSpace(req.Name)
+		if !roleNamePattern.MatchString(req.Name) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Role name must be 1-32 lowercase letters, digits, dashes or underscores",
+			})
+		}
+
+		permissions, err := validatePermissions(req.Permissions)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		if !middleware.AllowedAll(c, permissions) {
+			return roleTooPowerful(c)
+		}
+
+		var count int64
+		db.Unscoped().Model(&models.Role{}).Where("name = ?", req.Name).Count(&count)
+		if count > 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "A role with this name already exists",
+			})
+		}
+
+		role := models.Role{
+			Name:             req.Name,
+			Permissions:      permissions,
+			RequireTwoFactor: req.RequireTwoFactor,
+		}
+
+		if err := db.Create(&role).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create role",
+			})
+		}
+
+		auditor.Record(c, "role.create", "role", &role.ID, map[string]interface{}{
+			"name":               role.Name,
+			"permissions":        role.Permissions,
+			"require_two_factor": role.RequireTwoFactor,
+		})
+
+		return c.Status(fiber.StatusCreated).JSON(role)
+	}
+}
+
+func updateRole(db *gorm.DB, authz *middleware.Authorizer, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			Name             *string             `json:"name"`
+			Permissions      *models.Permissions `json:"permissions"`
+			RequireTwoFactor *bool               `json:"require_two_factor"`
+		}
+
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		var role models.Role
+		if err := db.First(&role, c.Params("id")).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Role not found",
+			})
+		}
+		previous := role
+
+		if !middleware.AllowedAll(c, role.Permissions) {
+			return roleTooPowerful(c)
+		}
+
+		// Admins must always keep every permission, or nobody could undo
+		// the change. Enforcing two-factor on them is fine.
+		if role.Name == models.RoleAdmin && (req.Name != nil && *req.Name != role.Name || req.Permissions != nil) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "The admin role's name and permissions cannot be modified",
+			})
+		}
+
+		if req.Name != nil {
+			name := strings.TrimSpace(*req.Name)
+			if name != role.Name {
+				if isBuiltInRole(role.Name) {
+					return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+						"error": "Built-in roles cannot be renamed",
+					})
+				}
+				if !roleNamePattern.MatchString(name) {
+					return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+						"error": "Role name must be 1-32 lowercase letters, digits, dashes or underscores",
+					})
+				}
+
+				var count int64
+				db.Unscoped().Model(&models.Role{}).Where("name = ? AND id <> ?", name, role.ID).Count(&count)
+				if count > 0 {
+					return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+						"error": "A role with this name already exists",
+					})
+				}
+				role.Name = name
+			}
+		}
+
+		if req.Permissions != nil {
+			permissions, err := validatePermissions(*req.Permissions)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+			if !middleware.AllowedAll(c, permissions) {
+				return roleTooPowerful(c)
+			}
+			role.Permissions = permissions
+		}
+
+		if req.RequireTwoFactor != nil {
+			role.RequireTwoFactor = *req.RequireTwoFactor
+		}
+
+		if err := db.Save(&role).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update role",
+			})
+		}
+
+		authz.InvalidateRole(c.Context(), role.ID)
+
+		changes := map[string]interface{}{}
+		if role.Name != previous.Name {
+			changes["name"] = fiber.Map{"from": previous.Name, "to": role.Name}
+		}
+		if req.Permissions != nil {
+			changes["permissions"] = fiber.Map{"from": previous.Permissions, "to": role.Permissions}
+		}
+		if role.RequireTwoFactor != previous.RequireTwoFactor {
+			changes["require_two_factor"] = fiber.Map{"from": previous.RequireTwoFactor, "to": role.RequireTwoFactor}
+		}
+		if len(changes) > 0 {
+			auditor.Record(c, "role.update", "role", &role.ID, changes)
+		}
+
+		return c.JSON(role)
+	}
+}
+
+func deleteRole(db *gorm.DB, authz *middleware.Authorizer, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var role models.Role
+		if err := db.First(&role, c.Params("id")).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Role not found",
+			})
+		}
+
+		if isBuiltInRole(role.Name) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Built-in roles cannot be deleted",
+			})
+		}
+
+		var users int64
+		db.Model(&models.User{}).Where("role_id = ?", role.ID).Count(&users)
+		if users > 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": fmt.Sprintf("Role is still assigned to %d user(s)", users),
+			})
+		}
+
+		if err := db.Delete(&role).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete role",
+			})
+		}
+
+		authz.InvalidateRole(c.Context(), role.ID)
+
+		auditor.Record(c, "role.delete", "role", &role.ID, map[string]interface{}{
+			"name": role.Name,
+		})
+
+		return c.JSON(fiber.Map{
+			"message": "Role deleted",
+		})
+	}
+}
+
+var errLastAdmin = errors.New("the last admin cannot be given another role")
+
+// countAdmins counts the users with the admin role and locks their rows
+// until tx ends, so two requests can't each take away one of the last two
+// admins.
+func countAdmins(tx *gorm.DB, adminRoleID uint) (int64, error) {
+	var ids []uint
+	err := tx.Model(&models.User{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("role_id = ?", adminRoleID).Pluck("id", &ids).Error
+	return int64(len(ids)), err
+}
+
+// assignRole moves a user to another role, refusing to demote the last
+// remaining admin.
+func assignRole(ctx context.Context, db *gorm.DB, authz *middleware.Authorizer, userID uint, roleID uint) (models.User, error) {
+	var user models.User
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Preload("Role").First(&user, userID).Error; err != nil {
+			return err
+		}
+
+		var role models.Role
+		if err := tx.First(&role, roleID).Error; err != nil {
+			return err
+		}
+
+		if user.Role.Name == models.RoleAdmin && role.Name != models.RoleAdmin {
+			admins, err := countAdmins(tx, user.RoleID)
+			if err != nil {
+				return err
+			}
+			if admins <= 1 {
+				return errLastAdmin
+			}
+		}
+
+		if err := tx.Model(&user).Update("role_id", role.ID).Error; err != nil {
+			return err
+		}
+		user.RoleID = role.ID
+		user.Role = role
+		return nil
+	})
+	if err != nil {
+		return user, err
+	}
+
+	authz.InvalidateUser(ctx, userID)
+	return user, nil
+}
+
+func assignUserRole(db *gorm.DB, authz *middleware.Authorizer, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			RoleID uint `json:"role_id"`
+		}
+
+		if err := c.BodyParser(&req); err != nil || req.RoleID == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "role_id is required",
+			})
+		}
+
+		userID, err := c.ParamsInt("id")
+		if err != nil || userID <= 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+
+		var previous models.User
+		db.Preload("Role").First(&previous, userID)
+
+		// Neither the new role nor the one being taken away may outrank
+		// the actor
+		var role models.Role
+		if err := db.First(&role, req.RoleID).Error; err == nil && !middleware.AllowedAll(c, role.Permissions) {
+			return roleTooPowerful(c)
+		}
+		if previous.ID != 0 && !middleware.AllowedAll(c, previous.Role.Permissions) {
+			return roleTooPowerful(c)
+		}
+
+		user, err := assignRole(c.Context(), db, authz, uint(userID), req.RoleID)
+		if errors.Is(err, errLastAdmin) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "The last admin cannot be given another role",
+			})
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User or role not found",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to assign role",
+			})
+		}
+
+		if previous.RoleID != user.RoleID {
+			auditor.Record(c, "user.update", "user", &user.ID, map[string]interface{}{
+				"role": fiber.Map{"from": previous.Role.Name, "to": user.Role.Name},
+			})
+		}
+
+		return c.JSON(user)
+	}
+}
@@ -0,0 +1,542 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"gaming-panel/backend/audit"
+	"gaming-panel/backend/middleware"
+	"gaming-panel/backend/models"
+	"gaming-panel/backend/routes/auth"
+	serverroutes "gaming-panel/backend/routes/servers"
+	"gaming-panel/backend/websocket/hub"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultUsersPerPage = 25
+	maxUsersPerPage     = 100
+)
+
+func listUsers(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		page := c.QueryInt("page", 1)
+		if page < 1 {
+			page = 1
+		}
+		perPage := c.QueryInt("per_page", defaultUsersPerPage)
+		if perPage < 1 || perPage > maxUsersPerPage {
+			perPage = defaultUsersPerPage
+		}
+
+		query := db.Model(&models.User{})
+		if search := strings.TrimSpace(c.Query("search")); search != "" {
+			pattern := "%" + strings.ToLower(search) + "%"
+			query = query.Where("LOWER(email) LIKE ? OR LOWER(username) LIKE ?", pattern, pattern)
+		}
+		if roleID := c.QueryInt("role_id"); roleID > 0 {
+			query = query.Where("role_id = ?", roleID)
+		}
+
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch users",
+			})
+		}
+
+		var users []models.User
+		if err := query.Preload("Role").
+			Order("id").
+			Limit(perPage).
+			Offset((page - 1) * perPage).
+			Find(&users).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch users",
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"data":     users,
+			"page":     page,
+			"per_page": perPage,
+			"total":    total,
+		})
+	}
+}
+
+func getUser(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var user models.User
+		if err := db.Preload("Role").First(&user, c.Params("id")).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+
+		var servers int64
+		db.Model(&models.Server{}).Where("owner_id = ?", user.ID).Count(&servers)
+
+		return c.JSON(fiber.Map{
+			"user":         user,
+			"server_count": servers,
+		})
+	}
+}
+
+// validateIdentity checks an email and username and makes sure no other user
+// holds them.
+func validateIdentity(db *gorm.DB, email, username string, exceptID uint) (int, string) {
+	if _, err := mail.ParseAddress(email); err != nil {
+		return fiber.StatusBadRequest, "Invalid email address"
+	}
+	if username == "" || len(username) > 64 {
+		return fiber.StatusBadRequest, "Username must be 1-64 characters"
+	}
+
+	var count int64
+	db.Unscoped().Model(&models.User{}).
+		Where("(email = ? OR username = ?) AND id <> ?", email, username, exceptID).
+		Count(&count)
+	if count > 0 {
+		return fiber.StatusConflict, "Email or username is already taken"
+	}
+	return 0, ""
+}
+
+func createUser(db *gorm.DB, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			Email    string `json:"email"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+			RoleID   uint   `json:"role_id"`
+			// Make the user pick their own password on first login
+			PasswordResetRequired bool `json:"password_reset_required"`
+		}
+
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		req.Email = strings.TrimSpace(req.Email)
+		req.Username = strings.TrimSpace(req.Username)
+		if status, message := validateIdentity(db, req.Email, req.Username, 0); status != 0 {
+			return c.Status(status).JSON(fiber.Map{
+				"error": message,
+			})
+		}
+
+		if len(req.Password) < models.MinPasswordLength {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Password must be at least %d characters", models.MinPasswordLength),
+			})
+		}
+
+		// Picking a role is role management, and only within the actor's
+		// own permissions
+		if req.RoleID != 0 && !middleware.Allowed(c, models.PermissionRoleManage) {
+			return middleware.Forbidden(c, models.PermissionRoleManage)
+		}
+
+		var role models.Role
+		roleQuery := db.Where("name = ?", models.RoleUser)
+		if req.RoleID != 0 {
+			roleQuery = db.Where("id = ?", req.RoleID)
+		}
+		if err := roleQuery.First(&role).Error; err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Role not found",
+			})
+		}
+		if req.RoleID != 0 && !middleware.AllowedAll(c, role.Permissions) {
+			return roleTooPowerful(c)
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to hash password",
+			})
+		}
+
+		user := models.User{
+			Email:                 req.Email,
+			Username:              req.Username,
+			PasswordHash:          string(hashedPassword),
+			RoleID:                role.ID,
+			Role:                  role,
+			PasswordResetRequired: req.PasswordResetRequired,
+		}
+
+		if err := db.Omit("Role").Create(&user).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create user",
+			})
+		}
+
+		auditor.Record(c, "user.create", "user", &user.ID, map[string]interface{}{
+			"email":    user.Email,
+			"username": user.Username,
+			"role":     role.Name,
+		})
+
+		return c.Status(fiber.StatusCreated).JSON(user)
+	}
+}
+
+func updateUser(db *gorm.DB, authz *middleware.Authorizer, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			Email    *string `json:"email"`
+			Username *string `json:"username"`
+			RoleID   *uint   `json:"role_id"`
+		}
+
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		var user models.User
+		if err := db.Preload("Role").First(&user, c.Params("id")).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+
+		// Changing the role is role management, held to the same rules as
+		// assignUserRole
+		if req.RoleID != nil && *req.RoleID != user.RoleID {
+			if !middleware.Allowed(c, models.PermissionRoleManage) {
+				return middleware.Forbidden(c, models.PermissionRoleManage)
+			}
+			var role models.Role
+			if err := db.First(&role, *req.RoleID).Error; err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Role not found",
+				})
+			}
+			if !middleware.AllowedAll(c, role.Permissions) || !middleware.AllowedAll(c, user.Role.Permissions) {
+				return roleTooPowerful(c)
+			}
+		}
+
+		changes := map[string]interface{}{}
+		email, username := user.Email, user.Username
+		if req.Email != nil {
+			email = strings.TrimSpace(*req.Email)
+		}
+		if req.Username != nil {
+			username = strings.TrimSpace(*req.Username)
+		}
+		// Whoever controls the email can reset the password, so it would
+		// hand over the account
+		if email != user.Email && !middleware.AllowedAll(c, user.Role.Permissions) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "You cannot change the email of a user with permissions you don't have",
+			})
+		}
+		if email != user.Email || username != user.Username {
+			if status, message := validateIdentity(db, email, username, user.ID); status != 0 {
+				return c.Status(status).JSON(fiber.Map{
+					"error": message,
+				})
+			}
+
+			updates := map[string]interface{}{
+				"email":    email,
+				"username": username,
+			}
+			if email != user.Email {
+				changes["email"] = fiber.Map{"from": user.Email, "to": email}
+				// The new address hasn't been verified
+				updates["email_verified_at"] = nil
+			}
+			if username != user.Username {
+				changes["username"] = fiber.Map{"from": user.Username, "to": username}
+			}
+
+			if err := db.Model(&user).Updates(updates).Error; err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to update user",
+				})
+			}
+			if email != user.Email {
+				user.EmailVerifiedAt = nil
+			}
+			user.Email, user.Username = email, username
+		}
+
+		if req.RoleID != nil && *req.RoleID != user.RoleID {
+			previousRole := user.Role.Name
+			updated, err := assignRole(c.Context(), db, authz, user.ID, *req.RoleID)
+			if errors.Is(err, errLastAdmin) {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error": "The last admin cannot be given another role",
+				})
+			}
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Role not found",
+				})
+			}
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to assign role",
+				})
+			}
+
+			changes["role"] = fiber.Map{"from": previousRole, "to": updated.Role.Name}
+			user = updated
+		}
+
+		if len(changes) > 0 {
+			auditor.Record(c, "user.update", "user", &user.ID, changes)
+		}
+
+		return c.JSON(user)
+	}
+}
+
+// setUserSuspended suspends or unsuspends a user. Admins can't suspend
+// themselves. Suspending closes the user's WebSocket connections.
+func setUserSuspended(db *gorm.DB, redisClient *redis.Client, authz *middleware.Authorizer, auditor *audit.Logger, suspend bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		actorID := uint(c.Locals("user_id").(float64))
+
+		var user models.User
+		if err := db.First(&user, c.Params("id")).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+
+		if suspend && user.ID == actorID {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "You cannot suspend your own account",
+			})
+		}
+
+		var suspendedAt *time.Time
+		action := "user.unsuspend"
+		if suspend {
+			now := time.Now()
+			suspendedAt = &now
+			action = "user.suspend"
+		}
+
+		if err := db.Model(&user).Update("suspended_at", suspendedAt).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update user",
+			})
+		}
+		user.SuspendedAt = suspendedAt
+
+		authz.InvalidateUser(c.Context(), user.ID)
+		if suspend {
+			hub.PublishRevocation(c.Context(), redisClient, hub.Revocation{UserID: user.ID})
+		}
+		auditor.Record(c, action, "user", &user.ID, nil)
+
+		return c.JSON(user)
+	}
+}
+
+// forcePasswordReset blocks the user until they change their password.
+func forcePasswordReset(db *gorm.DB, authz *middleware.Authorizer, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var user models.User
+		if err := db.First(&user, c.Params("id")).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+
+		if err := db.Model(&user).Update("password_reset_required", true).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update user",
+			})
+		}
+		user.PasswordResetRequired = true
+
+		authz.InvalidateUser(c.Context(), user.ID)
+		auditor.Record(c, "user.password_reset", "user", &user.ID, nil)
+
+		return c.JSON(user)
+	}
+}
+
+// resetTwoFactor removes a user's two-factor enrolment, e.g. when they lost
+// both their authenticator and their recovery codes.
+func resetTwoFactor(db *gorm.DB, authz *middleware.Authorizer, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var user models.User
+		if err := db.First(&user, c.Params("id")).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+
+		if err := auth.ResetTwoFactor(db, user.ID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to reset two-factor authentication",
+			})
+		}
+		user.TOTPEnabledAt = nil
+
+		authz.InvalidateUser(c.Context(), user.ID)
+		auditor.Record(c, "user.2fa_reset", "user", &user.ID, nil)
+
+		return c.JSON(user)
+	}
+}
+
+var errLastAdminDelete = errors.New("the last admin cannot be deleted")
+
+// deleteUser removes a user. Their servers are either handed to another user
+// (?servers=transfer&transfer_to=<user id>) or deleted (?servers=delete),
+// in which case their nodes remove the containers; users who still own
+// servers can't be deleted without choosing one.
+func deleteUser(db *gorm.DB, redisClient *redis.Client, authz *middleware.Authorizer, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		actorID := uint(c.Locals("user_id").(float64))
+
+		var user models.User
+		if err := db.Preload("Role").First(&user, c.Params("id")).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+
+		if user.ID == actorID {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "You cannot delete your own account",
+			})
+		}
+
+		var servers []models.Server
+		db.Where("owner_id = ?", user.ID).Find(&servers)
+
+		strategy := c.Query("servers")
+		var transferTo models.User
+		switch {
+		case len(servers) == 0:
+		case strategy == "transfer":
+			if err := db.First(&transferTo, c.QueryInt("transfer_to")).Error; err != nil || transferTo.ID == user.ID {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "transfer_to must be another existing user",
+				})
+			}
+		case strategy == "delete":
+		default:
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error":        "User still owns servers; pass servers=transfer&transfer_to=<user id> or servers=delete",
+				"server_count": len(servers),
+			})
+		}
+
+		backups := map[uint][]models.Backup{}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if user.Role.Name == models.RoleAdmin {
+				admins, err := countAdmins(tx, user.RoleID)
+				if err != nil {
+					return err
+				}
+				if admins <= 1 {
+					return errLastAdminDelete
+				}
+			}
+
+			if len(servers) > 0 {
+				if strategy == "transfer" {
+					if err := tx.Model(&models.Server{}).Where("owner_id = ?", user.ID).Update("owner_id", transferTo.ID).Error; err != nil {
+						return err
+					}
+				} else {
+					for _, server := range servers {
+						if server.AllocationID > 0 {
+							if err := tx.Model(&models.Allocation{}).Where("id = ?", server.AllocationID).Update("assigned", false).Error; err != nil {
+								return err
+							}
+						}
+						if err := tx.Where("server_id = ?", server.ID).Delete(&models.Subuser{}).Error; err != nil {
+							return err
+						}
+						deleted, err := serverroutes.DeleteBackups(tx, server.ID)
+						if err != nil {
+							return err
+						}
+						backups[server.ID] = deleted
+						if err := tx.Delete(&server).Error; err != nil {
+							return err
+						}
+					}
+				}
+			}
+			if err := tx.Where("user_id = ? OR email = ?", user.ID, user.Email).Delete(&models.Subuser{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.ID).Delete(&models.APIKey{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.ID).Delete(&models.UserIdentity{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&user).Error
+		})
+		if errors.Is(err, errLastAdminDelete) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "The last admin cannot be deleted",
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete user",
+			})
+		}
+
+		authz.InvalidateUser(c.Context(), user.ID)
+		hub.PublishRevocation(c.Context(), redisClient, hub.Revocation{UserID: user.ID})
+
+		if strategy == "delete" {
+			for _, server := range servers {
+				serverroutes.PublishDelete(c.Context(), db, redisClient, server, backups[server.ID])
+			}
+		}
+
+		metadata := map[string]interface{}{
+			"email":    user.Email,
+			"username": user.Username,
+		}
+		if len(servers) > 0 {
+			serverIDs := make([]uint, len(servers))
+			for i, server := range servers {
+				serverIDs[i] = server.ID
+			}
+			metadata["servers"] = strategy
+			metadata["server_ids"] = serverIDs
+			if strategy == "transfer" {
+				metadata["transfer_to"] = transferTo.ID
+			}
+		}
+		auditor.Record(c, "user.delete", "user", &user.ID, metadata)
+
+		return c.JSON(fiber.Map{
+			"message": "User deleted",
+		})
+	}
+}
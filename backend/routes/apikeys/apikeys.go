@@ -0,0 +1,249 @@
+package apikeys
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"gaming-panel/backend/audit"
+	"gaming-panel/backend/middleware"
+	"gaming-panel/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// maxKeysPerUser bounds how many active keys one account can hold.
+const maxKeysPerUser = 25
+
+func SetupAPIKeyRoutes(router fiber.Router, db *gorm.DB, authz *middleware.Authorizer, auditor *audit.Logger) {
+	// Keys can't be used to mint or revoke other keys, and a revoked
+	// session can't either
+	router.Use(requireSession, authz.RequireSession())
+
+	router.Get("/", listAPIKeys(db))
+	router.Post("/", createAPIKey(db, authz, auditor))
+	router.Delete("/:id", revokeAPIKey(db, auditor))
+}
+
+func requireSession(c *fiber.Ctx) error {
+	if usingAPIKey(c) {
+		return apiKeyForbidden(c)
+	}
+	return c.Next()
+}
+
+// usingAPIKey reports whether the request was authenticated with an API key.
+// createAPIKey and revokeAPIKey check it themselves too, so a key can't
+// create a key without its own scopes and IP allowlist wherever they're
+// mounted.
+func usingAPIKey(c *fiber.Ctx) bool {
+	return c.Locals("api_key_id") != nil
+}
+
+func apiKeyForbidden(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error": "API keys cannot manage API keys",
+	})
+}
+
+func listAPIKeys(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("user_id").(float64)
+
+		var keys []models.APIKey
+		if err := db.Where("user_id = ?", uint(userID)).Order("created_at DESC").Find(&keys).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch API keys",
+			})
+		}
+
+		return c.JSON(keys)
+	}
+}
+
+// createAPIKey issues a key. The key is only ever returned by this call.
+func createAPIKey(db *gorm.DB, authz *middleware.Authorizer, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if usingAPIKey(c) {
+			return apiKeyForbidden(c)
+		}
+		userID := c.Locals("user_id").(float64)
+
+		var req struct {
+			Name       string            `json:"name"`
+			Type       models.APIKeyType `json:"type"`
+			Scopes     []string          `json:"scopes"`      // defaults to everything the key type allows
+			AllowedIPs []string          `json:"allowed_ips"` // IPs or CIDRs
+			ExpiresAt  *time.Time        `json:"expires_at"`
+		}
+
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || len(req.Name) > 64 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Name must be 1-64 characters",
+			})
+		}
+
+		if req.Type == "" {
+			req.Type = models.APIKeyTypePersonal
+		}
+		if req.Type != models.APIKeyTypePersonal && req.Type != models.APIKeyTypeApplication {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Type must be personal or application",
+			})
+		}
+
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "expires_at must be in the future",
+			})
+		}
+
+		for _, entry := range req.AllowedIPs {
+			if net.ParseIP(entry) == nil {
+				if _, _, err := net.ParseCIDR(entry); err != nil {
+					return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+						"error": fmt.Sprintf("Invalid IP or CIDR %q", entry),
+					})
+				}
+			}
+		}
+
+		permissions, err := authz.Permissions(c.Context(), uint(userID))
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Failed to resolve permissions",
+			})
+		}
+
+		if req.Type == models.APIKeyTypeApplication && !permissions.Has(models.PermissionAPIApplication) {
+			return middleware.Forbidden(c, models.PermissionAPIApplication)
+		}
+
+		scopes, err := resolveScopes(req.Type, req.Scopes, permissions)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		var count int64
+		db.Model(&models.APIKey{}).Where("user_id = ?", uint(userID)).Count(&count)
+		if count >= maxKeysPerUser {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": fmt.Sprintf("You can have at most %d API keys", maxKeysPerUser),
+			})
+		}
+
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to generate API key",
+			})
+		}
+		secret := middleware.APIKeyPrefix + hex.EncodeToString(buf)
+
+		key := models.APIKey{
+			UserID:     uint(userID),
+			Name:       req.Name,
+			Type:       req.Type,
+			Prefix:     secret[:len(middleware.APIKeyPrefix)+8],
+			KeyHash:    middleware.HashAPIKey(secret),
+			Scopes:     scopes,
+			AllowedIPs: req.AllowedIPs,
+			ExpiresAt:  req.ExpiresAt,
+		}
+
+		if err := db.Create(&key).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create API key",
+			})
+		}
+
+		auditor.Record(c, "api_key.create", "api_key", &key.ID, map[string]interface{}{
+			"name":   key.Name,
+			"type":   key.Type,
+			"scopes": key.Scopes,
+		})
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"api_key": key,
+			"key":     secret,
+		})
+	}
+}
+
+// resolveScopes validates requested scopes against what the key type allows
+// and what the creator holds. No scopes means everything allowed.
+func resolveScopes(keyType models.APIKeyType, requested []string, held models.Permissions) (models.Permissions, error) {
+	allowed := func(scope string) bool {
+		if keyType == models.APIKeyTypePersonal {
+			return models.PersonalAPIKeyScopes[scope]
+		}
+		_, known := models.PermissionCatalogue[scope]
+		return known
+	}
+
+	if len(requested) == 0 {
+		if keyType == models.APIKeyTypeApplication {
+			return models.Permissions{models.PermissionAll: true}, nil
+		}
+		scopes := models.Permissions{}
+		for scope := range models.PersonalAPIKeyScopes {
+			scopes[scope] = true
+		}
+		return scopes, nil
+	}
+
+	scopes := models.Permissions{}
+	for _, scope := range requested {
+		if !allowed(scope) {
+			return nil, fmt.Errorf("scope %q is not available for %s keys", scope, keyType)
+		}
+		if !held.Has(scope) {
+			return nil, fmt.Errorf("you do not hold the %q permission", scope)
+		}
+		scopes[scope] = true
+	}
+	return scopes, nil
+}
+
+func revokeAPIKey(db *gorm.DB, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if usingAPIKey(c) {
+			return apiKeyForbidden(c)
+		}
+		userID := c.Locals("user_id").(float64)
+
+		var key models.APIKey
+		if err := db.Where("id = ? AND user_id = ?", c.Params("id"), uint(userID)).First(&key).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "API key not found",
+			})
+		}
+
+		if err := db.Delete(&key).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to revoke API key",
+			})
+		}
+
+		auditor.Record(c, "api_key.revoke", "api_key", &key.ID, map[string]interface{}{
+			"name": key.Name,
+		})
+
+		return c.JSON(fiber.Map{
+			"message": "API key revoked",
+		})
+	}
+}
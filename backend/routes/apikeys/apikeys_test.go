@@ -0,0 +1,38 @@
+package apikeys
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A scoped or IP-locked key must not be able to mint an unrestricted one or
+// revoke the keys that replace it, even if the routes are mounted without
+// requireSession.
+func TestAPIKeysCannotManageKeys(t *testing.T) {
+	app := fiber.New()
+	asKey := func(c *fiber.Ctx) error {
+		c.Locals("user_id", float64(1))
+		c.Locals("api_key_id", uint(1))
+		return c.Next()
+	}
+	app.Post("/api-keys", asKey, createAPIKey(nil, nil, nil))
+	app.Delete("/api-keys/:id", asKey, revokeAPIKey(nil, nil))
+
+	for _, req := range []struct{ method, path string }{
+		{fiber.MethodPost, "/api-keys"},
+		{fiber.MethodDelete, "/api-keys/1"},
+	} {
+		r := httptest.NewRequest(req.method, req.path, strings.NewReader(`{"name":"escalate","type":"application"}`))
+		r.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(r)
+		if err != nil {
+			t.Fatalf("%s %s: %v", req.method, req.path, err)
+		}
+		if resp.StatusCode != fiber.StatusForbidden {
+			t.Errorf("%s %s with an API key: status %d, want 403", req.method, req.path, resp.StatusCode)
+		}
+	}
+}
@@ -1,29 +1,63 @@
 package auth
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"gaming-panel/backend/audit"
 	"gaming-panel/backend/config"
+	"gaming-panel/backend/mailer"
 	"gaming-panel/backend/middleware"
 	"gaming-panel/backend/models"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/redis/go-redis/v9"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
-func SetupAuthRoutes(router fiber.Router, db *gorm.DB, redisClient *redis.Client, cfg *config.Config) {
-	router.Post("/login", login(db, redisClient, cfg))
-	router.Post("/register", register(db, cfg))
-	router.Post("/refresh", refreshToken(redisClient, cfg))
-	router.Post("/logout", logout(redisClient))
+func SetupAuthRoutes(router fiber.Router, db *gorm.DB, redisClient *redis.Client, authz *middleware.Authorizer, mail mailer.Mailer, auditor *audit.Logger, cfg *config.Config) {
+	router.Post("/login", middleware.RateLimit(redisClient, loginIPLimit, middleware.ByIP), login(db, redisClient, mail, auditor, cfg))
+	router.Post("/register", middleware.RateLimit(redisClient, registerIPLimit, middleware.ByIP), register(db, redisClient, mail, auditor, cfg))
+	router.Post("/refresh", middleware.RateLimit(redisClient, refreshIPLimit, middleware.ByIP), refreshToken(db, redisClient, cfg))
+	router.Post("/logout", logout(redisClient, auditor))
+
+	// Access tokens stop working as soon as their session is revoked
+	session := authz.RequireSession()
+	router.Get("/sessions", RequireAuth(), session, listSessions(redisClient))
+	router.Delete("/sessions", RequireAuth(), session, revokeAllSessions(redisClient, auditor))
+	router.Delete("/sessions/:id", RequireAuth(), session, revokeSession(redisClient, auditor))
+
+	router.Post("/password", RequireAuth(), session, changePassword(db, redisClient, authz, auditor))
+	router.Post("/password/forgot", middleware.RateLimit(redisClient, forgotIPLimit, middleware.ByIP), forgotPassword(db, redisClient, mail, cfg))
+	router.Post("/password/reset", resetPassword(db, redisClient, authz, auditor, cfg))
+	router.Post("/email/verify", verifyEmail(db, redisClient, cfg))
+	router.Post("/email/resend", RequireAuth(), session, middleware.RateLimit(redisClient, verificationUserLimit, middleware.ByUser), resendVerification(db, mail, cfg))
+
+	sso := newOIDCClient(cfg)
+	router.Get("/oidc", oidcInfo(sso, cfg))
+	router.Get("/oidc/authorize", middleware.RateLimit(redisClient, loginIPLimit, middleware.ByIP), oidcAuthorize(sso, redisClient))
+	router.Post("/oidc/callback", middleware.RateLimit(redisClient, loginIPLimit, middleware.ByIP), oidcCallback(db, redisClient, sso, auditor, cfg))
+
+	router.Post("/2fa/verify", middleware.RateLimit(redisClient, loginIPLimit, middleware.ByIP), verifyChallenge(db, redisClient, auditor, cfg))
+	router.Post("/2fa/enroll", RequireAuth(), session, enrollTwoFactor(db, redisClient, cfg))
+	router.Post("/2fa/confirm", RequireAuth(), session, confirmTwoFactor(db, redisClient, authz, auditor))
+	router.Post("/2fa/disable", RequireAuth(), session, disableTwoFactor(db, redisClient, authz, auditor))
+	router.Post("/2fa/recovery-codes", RequireAuth(), session, regenerateRecoveryCodes(db, redisClient, auditor))
 }
 
 func RequireAuth() fiber.Handler {
 	return middleware.AuthMiddleware
 }
 
-func login(db *gorm.DB, redisClient *redis.Client, cfg *config.Config) fiber.Handler {
+// dummyPasswordHash is checked when nobody has the email, so a login takes
+// as long whether or not the account exists.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+func login(db *gorm.DB, redisClient *redis.Client, mail mailer.Mailer, auditor *audit.Logger, cfg *config.Config) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		var req struct {
 			Email    string `json:"email"`
@@ -36,46 +70,97 @@ func login(db *gorm.DB, redisClient *redis.Client, cfg *config.Config) fiber.Han
 			})
 		}
 
+		account := accountID(req.Email)
+		if wait := loginLockedFor(c.Context(), redisClient, account, c.IP()); wait > 0 {
+			return middleware.TooManyRequests(c, wait, "Too many failed login attempts, try again later")
+		}
+		if result := middleware.ApplyRateLimit(c, redisClient, loginAccountLimit, account); !result.Allowed {
+			return middleware.TooManyRequests(c, result.Reset, "Too many login attempts, try again later")
+		}
+
 		var user models.User
 		if err := db.Preload("Role").Where("email = ?", req.Email).First(&user).Error; err != nil {
+			bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(req.Password))
+			recordLoginFailure(c.Context(), redisClient, account, c.IP())
+			auditor.RecordAs(c, nil, "auth.login_failed", "user", nil, map[string]interface{}{
+				"email":  req.Email,
+				"reason": "unknown_user",
+			})
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Invalid credentials",
 			})
 		}
 
 		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+			recordLoginFailure(c.Context(), redisClient, account, c.IP())
+			auditor.RecordAs(c, &user.ID, "auth.login_failed", "user", &user.ID, map[string]interface{}{
+				"email":  req.Email,
+				"reason": "invalid_password",
+			})
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Invalid credentials",
 			})
 		}
 
-		// Generate JWT token
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"user_id": user.ID,
-			"email":   user.Email,
-			"role_id": user.RoleID,
-		})
-
-		tokenString, err := token.SignedString([]byte(cfg.JWTSecret))
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to generate token",
+		if user.SuspendedAt != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Your account has been suspended",
 			})
 		}
 
-		// Store refresh token in Redis
-		refreshToken := generateRefreshToken()
-		redisClient.Set(c.Context(), "refresh:"+refreshToken, user.ID, 0)
+		if cfg.RequireEmailVerification && user.EmailVerifiedAt == nil {
+			return emailVerificationRequired(c, redisClient, mail, cfg, user)
+		}
 
-		return c.JSON(fiber.Map{
-			"token":         tokenString,
-			"refresh_token": refreshToken,
-			"user":          user,
+		if user.TOTPEnabledAt != nil {
+			return startChallenge(c, redisClient, user)
+		}
+
+		return completeLogin(c, db, redisClient, auditor, cfg, user, "password")
+	}
+}
+
+// completeLogin starts a session for a user who passed every login check.
+// The user's role must be loaded. method is how they logged in, for the
+// audit log.
+func completeLogin(c *fiber.Ctx, db *gorm.DB, redisClient *redis.Client, auditor *audit.Logger, cfg *config.Config, user models.User, method string) error {
+	accountLockout.clear(c.Context(), redisClient, accountID(user.Email))
+
+	now := time.Now()
+	db.Model(&user).UpdateColumn("last_login_at", now)
+	user.LastLoginAt = &now
+
+	refreshToken, familyID, err := startSession(c.Context(), redisClient, cfg, user.ID, c.IP(), c.Get("User-Agent"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start session",
 		})
 	}
+
+	tokenString, err := issueAccessToken(cfg, user, familyID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate token",
+		})
+	}
+
+	auditor.RecordAs(c, &user.ID, "auth.login", "user", &user.ID, map[string]interface{}{
+		"method":     method,
+		"session_id": familyID,
+	})
+
+	// Accounts flagged for a password reset or missing a two-factor
+	// enrolment their role requires can only fix that with this token
+	return c.JSON(fiber.Map{
+		"token":                     tokenString,
+		"refresh_token":             refreshToken,
+		"user":                      user,
+		"password_reset_required":   user.PasswordResetRequired,
+		"two_factor_setup_required": user.Role.RequireTwoFactor && user.TOTPEnabledAt == nil,
+	})
 }
 
-func register(db *gorm.DB, cfg *config.Config) fiber.Handler {
+func register(db *gorm.DB, redisClient *redis.Client, mail mailer.Mailer, auditor *audit.Logger, cfg *config.Config) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		var req struct {
 			Email    string `json:"email"`
@@ -89,6 +174,16 @@ func register(db *gorm.DB, cfg *config.Config) fiber.Handler {
 			})
 		}
 
+		if result := middleware.ApplyRateLimit(c, redisClient, registerAccountLimit, accountID(req.Email)); !result.Allowed {
+			return middleware.TooManyRequests(c, result.Reset, "Too many registration attempts, try again later")
+		}
+
+		if len(req.Password) < models.MinPasswordLength {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Password must be at least %d characters", models.MinPasswordLength),
+			})
+		}
+
 		// Hash password
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 		if err != nil {
@@ -97,25 +192,20 @@ func register(db *gorm.DB, cfg *config.Config) fiber.Handler {
 			})
 		}
 
-		// Get default role (user)
-		var defaultRole models.Role
-		if err := db.Where("name = ?", "user").First(&defaultRole).Error; err != nil {
-			// Create default role if not exists
-			defaultRole = models.Role{
-				Name: "user",
-				Permissions: models.Permissions{
-					"server.view":   true,
-					"server.manage": true,
-				},
-			}
-			db.Create(&defaultRole)
+		// Admins are made with the create-admin command, never by
+		// registering
+		var role models.Role
+		if err := db.Where("name = ?", models.RoleUser).First(&role).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Default role is missing",
+			})
 		}
 
 		user := models.User{
 			Email:        req.Email,
 			Username:     req.Username,
 			PasswordHash: string(hashedPassword),
-			RoleID:       defaultRole.ID,
+			RoleID:       role.ID,
 		}
 
 		if err := db.Create(&user).Error; err != nil {
@@ -124,6 +214,14 @@ func register(db *gorm.DB, cfg *config.Config) fiber.Handler {
 			})
 		}
 
+		if err := sendVerificationEmail(mail, cfg, user); err != nil {
+			log.Printf("Failed to prepare verification email for user %d: %v", user.ID, err)
+		}
+
+		auditor.RecordAs(c, &user.ID, "auth.register", "user", &user.ID, map[string]interface{}{
+			"role": role.Name,
+		})
+
 		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 			"message": "User created successfully",
 			"user":    user,
@@ -131,7 +229,7 @@ func register(db *gorm.DB, cfg *config.Config) fiber.Handler {
 	}
 }
 
-func refreshToken(redisClient *redis.Client, cfg *config.Config) fiber.Handler {
+func refreshToken(db *gorm.DB, redisClient *redis.Client, cfg *config.Config) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		var req struct {
 			RefreshToken string `json:"refresh_token"`
@@ -143,19 +241,39 @@ func refreshToken(redisClient *redis.Client, cfg *config.Config) fiber.Handler {
 			})
 		}
 
-		userID, err := redisClient.Get(c.Context(), "refresh:"+req.RefreshToken).Result()
-		if err != nil {
+		if record, ok := lookupRefreshToken(c.Context(), redisClient, req.RefreshToken); ok {
+			id := strconv.FormatUint(uint64(record.UserID), 10)
+			if result := middleware.ApplyRateLimit(c, redisClient, refreshAccountLimit, id); !result.Allowed {
+				return middleware.TooManyRequests(c, result.Reset, "Too many refresh attempts, try again later")
+			}
+		}
+
+		newRefreshToken, userID, familyID, err := rotateRefreshToken(c.Context(), redisClient, cfg, req.RefreshToken, c.IP(), c.Get("User-Agent"))
+		if errors.Is(err, errRefreshTokenReused) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Refresh token was already used; the session has been revoked",
+			})
+		}
+		if errors.Is(err, errRefreshTokenInvalid) {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Invalid refresh token",
 			})
 		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to refresh session",
+			})
+		}
 
-		// Generate new access token
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"user_id": userID,
-		})
+		var user models.User
+		if err := db.First(&user, userID).Error; err != nil || user.SuspendedAt != nil {
+			revokeFamily(c.Context(), redisClient, familyID)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid refresh token",
+			})
+		}
 
-		tokenString, err := token.SignedString([]byte(cfg.JWTSecret))
+		tokenString, err := issueAccessToken(cfg, user, familyID)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to generate token",
@@ -163,16 +281,74 @@ func refreshToken(redisClient *redis.Client, cfg *config.Config) fiber.Handler {
 		}
 
 		return c.JSON(fiber.Map{
-			"token": tokenString,
+			"token":         tokenString,
+			"refresh_token": newRefreshToken,
 		})
 	}
 }
 
-func generateRefreshToken() string {
-	return "refresh_" + generateRandomString(32)
+// changePassword sets a new password and logs out every other session.
+func changePassword(db *gorm.DB, redisClient *redis.Client, authz *middleware.Authorizer, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("user_id").(float64)
+
+		var req struct {
+			CurrentPassword string `json:"current_password"`
+			NewPassword     string `json:"new_password"`
+		}
+
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		if len(req.NewPassword) < models.MinPasswordLength {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Password must be at least %d characters", models.MinPasswordLength),
+			})
+		}
+
+		var user models.User
+		if err := db.First(&user, uint(userID)).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+
+		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)); err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Current password is incorrect",
+			})
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to hash password",
+			})
+		}
+
+		if err := db.Model(&user).Updates(map[string]interface{}{
+			"password_hash":           string(hashedPassword),
+			"password_reset_required": false,
+		}).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update password",
+			})
+		}
+
+		revokeUserSessions(c.Context(), redisClient, user.ID, currentSession(c))
+		authz.InvalidateUser(c.Context(), user.ID)
+		auditor.Record(c, "auth.password_change", "user", &user.ID, nil)
+
+		return c.JSON(fiber.Map{
+			"message": "Password changed",
+		})
+	}
 }
 
-func logout(redisClient *redis.Client) fiber.Handler {
+func logout(redisClient *redis.Client, auditor *audit.Logger) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		var req struct {
 			RefreshToken string `json:"refresh_token"`
@@ -184,20 +360,16 @@ func logout(redisClient *redis.Client) fiber.Handler {
 			})
 		}
 
-		// Remove refresh token from Redis
-		redisClient.Del(c.Context(), "refresh:"+req.RefreshToken)
+		// End the session the refresh token belongs to
+		if record, ok := lookupRefreshToken(c.Context(), redisClient, req.RefreshToken); ok {
+			revokeFamily(c.Context(), redisClient, record.FamilyID)
+			auditor.RecordAs(c, &record.UserID, "auth.logout", "user", &record.UserID, map[string]interface{}{
+				"session_id": record.FamilyID,
+			})
+		}
 
 		return c.JSON(fiber.Map{
 			"message": "Logged out successfully",
 		})
 	}
 }
-
-func generateRandomString(length int) string {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = charset[i%len(charset)]
-	}
-	return string(b)
-}
@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"gaming-panel/backend/audit"
+	"gaming-panel/backend/config"
+	"gaming-panel/backend/mailer"
+	"gaming-panel/backend/middleware"
+	"gaming-panel/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	passwordResetTTL = time.Hour
+	verifyEmailTTL   = 48 * time.Hour
+
+	// sendTimeout bounds how long delivering one email may take.
+	sendTimeout = 30 * time.Second
+)
+
+// formatTTL renders a token lifetime for emails, e.g. "1 hour" or "2 days".
+func formatTTL(d time.Duration) string {
+	count, unit := int(d.Hours()), "hour"
+	if count%24 == 0 {
+		count, unit = count/24, "day"
+	}
+	if count != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", count, unit)
+}
+
+// sendTokenEmail signs a token for purpose and mails the user a link to
+// path carrying it. Delivery happens in the background so responses don't
+// reveal whether an account exists.
+func sendTokenEmail(mail mailer.Mailer, cfg *config.Config, user models.User, purpose, template, path string, ttl time.Duration) error {
+	token, err := signMailToken(cfg, purpose, user, ttl)
+	if err != nil {
+		return err
+	}
+
+	msg, err := mailer.Render(template, map[string]interface{}{
+		"PanelName": cfg.PanelName,
+		"Username":  user.Username,
+		"Email":     user.Email,
+		"URL":       cfg.PanelURL + path + "?token=" + url.QueryEscape(token),
+		"ExpiresIn": formatTTL(ttl),
+	})
+	if err != nil {
+		return err
+	}
+	msg.To = user.Email
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		defer cancel()
+		if err := mail.Send(ctx, msg); err != nil {
+			log.Printf("Failed to send %s email to user %d: %v", template, user.ID, err)
+		}
+	}()
+	return nil
+}
+
+func sendVerificationEmail(mail mailer.Mailer, cfg *config.Config, user models.User) error {
+	return sendTokenEmail(mail, cfg, user, purposeVerifyEmail, "verify_email", "/auth/verify-email", verifyEmailTTL)
+}
+
+// emailVerificationRequired refuses a password login to an account whose
+// email isn't verified. The account may never have had a link, e.g. when an
+// admin created it, or the link may have expired, so a new one is sent
+// within the resend limit.
+func emailVerificationRequired(c *fiber.Ctx, redisClient *redis.Client, mail mailer.Mailer, cfg *config.Config, user models.User) error {
+	id := strconv.FormatUint(uint64(user.ID), 10)
+	if result, err := middleware.Allow(c.Context(), redisClient, verificationUserLimit, id); err == nil && result.Allowed {
+		if err := sendVerificationEmail(mail, cfg, user); err != nil {
+			log.Printf("Failed to prepare verification email for user %d: %v", user.ID, err)
+		}
+	}
+
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error":                       "Verify your email address before logging in; we have sent you a new link",
+		"email_verification_required": true,
+	})
+}
+
+// forgotPassword mails a reset link. It answers the same way whether or not
+// the account exists.
+func forgotPassword(db *gorm.DB, redisClient *redis.Client, mail mailer.Mailer, cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			Email string `json:"email"`
+		}
+
+		if err := c.BodyParser(&req); err != nil || req.Email == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "email is required",
+			})
+		}
+
+		if result := middleware.ApplyRateLimit(c, redisClient, forgotAccountLimit, accountID(req.Email)); !result.Allowed {
+			return middleware.TooManyRequests(c, result.Reset, "Too many reset requests, try again later")
+		}
+
+		var user models.User
+		if err := db.Where("LOWER(email) = LOWER(?)", req.Email).First(&user).Error; err == nil && user.SuspendedAt == nil {
+			if err := sendTokenEmail(mail, cfg, user, purposePasswordReset, "password_reset", "/auth/reset-password", passwordResetTTL); err != nil {
+				log.Printf("Failed to prepare password reset email for user %d: %v", user.ID, err)
+			}
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "If an account uses that email, a password reset link is on its way",
+		})
+	}
+}
+
+// resetPassword sets a new password with a token from forgotPassword and
+// signs the user out everywhere.
+func resetPassword(db *gorm.DB, redisClient *redis.Client, authz *middleware.Authorizer, auditor *audit.Logger, cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			Token       string `json:"token"`
+			NewPassword string `json:"new_password"`
+		}
+
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		if len(req.NewPassword) < models.MinPasswordLength {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Password must be at least %d characters", models.MinPasswordLength),
+			})
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to hash password",
+			})
+		}
+
+		user, err := consumeMailToken(c.Context(), db, redisClient, cfg, purposePasswordReset, req.Token)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid or expired reset link",
+			})
+		}
+
+		updates := map[string]interface{}{
+			"password_hash":           string(hashedPassword),
+			"password_reset_required": false,
+		}
+		// Following the link proves the user reads this inbox
+		if user.EmailVerifiedAt == nil {
+			updates["email_verified_at"] = time.Now()
+		}
+
+		if err := db.Model(&user).Updates(updates).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update password",
+			})
+		}
+
+		revokeUserSessions(c.Context(), redisClient, user.ID, "")
+		accountLockout.clear(c.Context(), redisClient, accountID(user.Email))
+		authz.InvalidateUser(c.Context(), user.ID)
+		auditor.RecordAs(c, &user.ID, "auth.password_reset", "user", &user.ID, nil)
+
+		return c.JSON(fiber.Map{
+			"message": "Password reset; please log in",
+		})
+	}
+}
+
+func verifyEmail(db *gorm.DB, redisClient *redis.Client, cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			Token string `json:"token"`
+		}
+
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		user, err := consumeMailToken(c.Context(), db, redisClient, cfg, purposeVerifyEmail, req.Token)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid or expired verification link",
+			})
+		}
+
+		if user.EmailVerifiedAt == nil {
+			if err := db.Model(&user).UpdateColumn("email_verified_at", time.Now()).Error; err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Failed to verify email",
+				})
+			}
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Email verified",
+		})
+	}
+}
+
+func resendVerification(db *gorm.DB, mail mailer.Mailer, cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("user_id").(float64)
+
+		var user models.User
+		if err := db.First(&user, uint(userID)).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+
+		if user.EmailVerifiedAt != nil {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Your email is already verified",
+			})
+		}
+
+		if err := sendVerificationEmail(mail, cfg, user); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to send verification email",
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Verification email sent",
+		})
+	}
+}
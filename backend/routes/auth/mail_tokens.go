@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"time"
+
+	"gaming-panel/backend/config"
+	"gaming-panel/backend/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// Purposes of the tokens sent by email. A token only works for its purpose.
+const (
+	purposePasswordReset = "password_reset"
+	purposeVerifyEmail   = "verify_email"
+)
+
+var errMailTokenInvalid = errors.New("invalid or expired token")
+
+func usedMailTokenKey(id string) string {
+	return "mail_token:used:" + id
+}
+
+// mailTokenKey derives the signing key for emailed tokens from the JWT
+// secret, so they can never pass for access tokens.
+func mailTokenKey(cfg *config.Config) []byte {
+	mac := hmac.New(sha256.New, []byte(cfg.JWTSecret))
+	mac.Write([]byte("mail-token"))
+	return mac.Sum(nil)
+}
+
+// mailTokenBinding ties a token to the state it is about to change. Reset
+// tokens die once the password changes and verification tokens once the
+// email does.
+func mailTokenBinding(purpose string, user models.User) string {
+	switch purpose {
+	case purposePasswordReset:
+		return hashToken(user.PasswordHash)[:16]
+	default:
+		return hashToken(strings.ToLower(user.Email))[:16]
+	}
+}
+
+// signMailToken issues a signed, expiring token for purpose.
+func signMailToken(cfg *config.Config, purpose string, user models.User, ttl time.Duration) (string, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"purpose": purpose,
+		"user_id": user.ID,
+		"binding": mailTokenBinding(purpose, user),
+		"jti":     id,
+		"iat":     now.Unix(),
+		"exp":     now.Add(ttl).Unix(),
+	})
+	return token.SignedString(mailTokenKey(cfg))
+}
+
+// consumeMailToken checks a token issued by signMailToken and spends it, so
+// it can't be used again. It returns the user the token was issued for.
+func consumeMailToken(ctx context.Context, db *gorm.DB, redisClient *redis.Client, cfg *config.Config, purpose, tokenString string) (models.User, error) {
+	var user models.User
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return mailTokenKey(cfg), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || !token.Valid {
+		return user, errMailTokenInvalid
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	userID, _ := claims["user_id"].(float64)
+	id, _ := claims["jti"].(string)
+	if claims["purpose"] != purpose || id == "" {
+		return user, errMailTokenInvalid
+	}
+
+	if err := db.First(&user, uint(userID)).Error; err != nil {
+		return user, errMailTokenInvalid
+	}
+	if claims["binding"] != mailTokenBinding(purpose, user) {
+		return user, errMailTokenInvalid
+	}
+
+	expiresAt, err := claims.GetExpirationTime()
+	if err != nil || time.Until(expiresAt.Time) <= 0 {
+		return user, errMailTokenInvalid
+	}
+	fresh, err := redisClient.SetNX(ctx, usedMailTokenKey(id), 1, time.Until(expiresAt.Time)).Result()
+	if err != nil || !fresh {
+		return user, errMailTokenInvalid
+	}
+	return user, nil
+}
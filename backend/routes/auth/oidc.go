@@ -0,0 +1,330 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"gaming-panel/backend/audit"
+	"gaming-panel/backend/config"
+	"gaming-panel/backend/models"
+	"gaming-panel/backend/oidc"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// oidcStateTTL is how long the user has to log in at the provider.
+const oidcStateTTL = 10 * time.Minute
+
+var (
+	errOIDCNoEmail         = errors.New("provider did not share an email address")
+	errOIDCEmailUnverified = errors.New("email not verified by provider")
+	// errOIDCAccountUnverified means the local account with the email never
+	// proved it owns it, so whoever registered it may not be the provider's user
+	errOIDCAccountUnverified = errors.New("existing account has not verified its email")
+
+	usernameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+)
+
+// oidcState is what a login started at the provider needs to finish.
+type oidcState struct {
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+}
+
+func oidcStateKey(state string) string {
+	return "oidc:state:" + hashToken(state)
+}
+
+// newOIDCClient returns nil when single sign-on isn't configured.
+func newOIDCClient(cfg *config.Config) *oidc.Client {
+	if cfg.OIDCIssuer == "" {
+		return nil
+	}
+	return oidc.NewClient(oidc.Config{
+		Issuer:       cfg.OIDCIssuer,
+		ClientID:     cfg.OIDCClientID,
+		ClientSecret: cfg.OIDCClientSecret,
+		RedirectURL:  cfg.OIDCRedirectURL,
+		Scopes:       strings.Fields(cfg.OIDCScopes),
+	})
+}
+
+func ssoNotConfigured(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+		"error": "Single sign-on is not configured",
+	})
+}
+
+// oidcInfo tells the login page whether to offer single sign-on.
+func oidcInfo(sso *oidc.Client, cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"enabled": sso != nil,
+			"name":    cfg.OIDCProviderName,
+		})
+	}
+}
+
+// oidcAuthorize starts a login at the provider. The frontend sends the user
+// to the returned URL; the provider sends them back to OIDC_REDIRECT_URL.
+func oidcAuthorize(sso *oidc.Client, redisClient *redis.Client) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if sso == nil {
+			return ssoNotConfigured(c)
+		}
+
+		state, err := oidc.RandomString(32)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to start single sign-on",
+			})
+		}
+		nonce, err := oidc.RandomString(32)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to start single sign-on",
+			})
+		}
+		verifier, challenge, err := oidc.NewPKCE()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to start single sign-on",
+			})
+		}
+
+		authURL, err := sso.AuthCodeURL(c.Context(), state, nonce, challenge)
+		if err != nil {
+			log.Printf("OIDC: %v", err)
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+				"error": "The identity provider is unavailable",
+			})
+		}
+
+		record, _ := json.Marshal(oidcState{Verifier: verifier, Nonce: nonce})
+		if err := redisClient.Set(c.Context(), oidcStateKey(state), record, oidcStateTTL).Err(); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to start single sign-on",
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"authorization_url": authURL,
+		})
+	}
+}
+
+// oidcCallback finishes a login with the code and state the provider
+// returned. It responds like login, including the two-factor challenge for
+// users who enabled it.
+func oidcCallback(db *gorm.DB, redisClient *redis.Client, sso *oidc.Client, auditor *audit.Logger, cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if sso == nil {
+			return ssoNotConfigured(c)
+		}
+
+		var req struct {
+			Code  string `json:"code"`
+			State string `json:"state"`
+		}
+
+		if err := c.BodyParser(&req); err != nil || req.Code == "" || req.State == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "code and state are required",
+			})
+		}
+
+		// GETDEL makes the state single use
+		raw, err := redisClient.GetDel(c.Context(), oidcStateKey(req.State)).Bytes()
+		var state oidcState
+		if err != nil || json.Unmarshal(raw, &state) != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Login expired or was already used; try again",
+			})
+		}
+
+		claims, err := sso.Exchange(c.Context(), req.Code, state.Verifier, state.Nonce)
+		if err != nil {
+			log.Printf("OIDC: %v", err)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "The identity provider did not confirm your login",
+			})
+		}
+
+		user, err := resolveOIDCUser(db, cfg, claims)
+		if errors.Is(err, errOIDCNoEmail) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "The identity provider did not share your email address",
+			})
+		}
+		if errors.Is(err, errOIDCEmailUnverified) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "An account with this email already exists. Verify your email with the identity provider, or log in with your password",
+			})
+		}
+		if errors.Is(err, errOIDCAccountUnverified) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "An account with this email already exists but its email is not verified. Log in with your password and verify it first",
+			})
+		}
+		if err != nil {
+			log.Printf("OIDC: failed to resolve user for %s: %v", claims.Subject, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to sign in",
+			})
+		}
+
+		if user.SuspendedAt != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Your account has been suspended",
+			})
+		}
+
+		if user.TOTPEnabledAt != nil {
+			return startChallenge(c, redisClient, user)
+		}
+
+		return completeLogin(c, db, redisClient, auditor, cfg, user, "oidc")
+	}
+}
+
+// resolveOIDCUser finds the user a provider account belongs to. Accounts
+// seen before are found by their identity. Otherwise the account is linked
+// to the user with the same email, if both the provider and that user
+// verified it, or a new user is created with OIDC_DEFAULT_ROLE. The user's
+// role is loaded.
+func resolveOIDCUser(db *gorm.DB, cfg *config.Config, claims oidc.Claims) (models.User, error) {
+	var user models.User
+	now := time.Now()
+
+	var identity models.UserIdentity
+	err := db.Where("issuer = ? AND subject = ?", cfg.OIDCIssuer, claims.Subject).First(&identity).Error
+	if err == nil {
+		db.Model(&identity).Updates(map[string]interface{}{
+			"email":         claims.Email,
+			"last_login_at": now,
+		})
+		err = db.Preload("Role").First(&user, identity.UserID).Error
+		return user, err
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, err
+	}
+
+	if claims.Email == "" {
+		return user, errOIDCNoEmail
+	}
+
+	identity = models.UserIdentity{
+		Issuer:      cfg.OIDCIssuer,
+		Subject:     claims.Subject,
+		Email:       claims.Email,
+		LastLoginAt: &now,
+	}
+
+	err = db.Where("LOWER(email) = LOWER(?)", claims.Email).First(&user).Error
+	if err == nil {
+		if !claims.EmailVerified {
+			return user, errOIDCEmailUnverified
+		}
+		// Someone may have registered the address without owning it;
+		// linking would hand the provider's user their account
+		if user.EmailVerifiedAt == nil {
+			return user, errOIDCAccountUnverified
+		}
+
+		identity.UserID = user.ID
+		if err := db.Create(&identity).Error; err != nil {
+			return user, err
+		}
+		err = db.Preload("Role").First(&user, user.ID).Error
+		return user, err
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, err
+	}
+
+	return createOIDCUser(db, cfg, claims, identity)
+}
+
+// createOIDCUser registers a user for a provider account. The user gets an
+// unguessable password and can set a real one with a password reset.
+func createOIDCUser(db *gorm.DB, cfg *config.Config, claims oidc.Claims, identity models.UserIdentity) (models.User, error) {
+	var user models.User
+
+	password, err := randomToken(32)
+	if err != nil {
+		return user, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return user, err
+	}
+
+	var role models.Role
+	if err := db.Where("name = ?", cfg.OIDCDefaultRole).First(&role).Error; err != nil {
+		return user, err
+	}
+
+	username, err := uniqueUsername(db, claims)
+	if err != nil {
+		return user, err
+	}
+
+	user = models.User{
+		Email:        claims.Email,
+		Username:     username,
+		PasswordHash: string(hashedPassword),
+		RoleID:       role.ID,
+	}
+	if claims.EmailVerified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		identity.UserID = user.ID
+		return tx.Create(&identity).Error
+	})
+	user.Role = role
+	return user, err
+}
+
+// uniqueUsername derives a free username from the provider's claims.
+func uniqueUsername(db *gorm.DB, claims oidc.Claims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = strings.Trim(usernameInvalidChars.ReplaceAllString(base, "-"), "-.")
+	if base == "" {
+		base = "user"
+	}
+	if len(base) > 32 {
+		base = base[:32]
+	}
+
+	candidate := base
+	for attempt := 0; attempt < 5; attempt++ {
+		var count int64
+		db.Model(&models.User{}).Where("LOWER(username) = LOWER(?)", candidate).Count(&count)
+		if count == 0 {
+			return candidate, nil
+		}
+		suffix, err := randomToken(3)
+		if err != nil {
+			return "", err
+		}
+		candidate = base + "-" + suffix
+	}
+	return "", errors.New("could not find a free username")
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"gaming-panel/backend/audit"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// session is how a refresh token family is shown to its user.
+type session struct {
+	ID         string    `json:"id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"` // the session making the request
+}
+
+// currentSession is the session the request's access token belongs to.
+func currentSession(c *fiber.Ctx) string {
+	sessionID, _ := c.Locals("session_id").(string)
+	return sessionID
+}
+
+// listSessions shows where the caller is logged in, most recently used
+// first.
+func listSessions(redisClient *redis.Client) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := uint(c.Locals("user_id").(float64))
+
+		familyIDs, err := redisClient.SMembers(c.Context(), userFamiliesKey(userID)).Result()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch sessions",
+			})
+		}
+
+		sessions := []session{}
+		for _, familyID := range familyIDs {
+			family, err := loadFamily(c.Context(), redisClient, familyID)
+			if errors.Is(err, redis.Nil) {
+				// Expired without a logout
+				redisClient.SRem(c.Context(), userFamiliesKey(userID), familyID)
+				continue
+			}
+			if err != nil {
+				continue
+			}
+
+			sessions = append(sessions, session{
+				ID:         familyID,
+				IP:         family.IP,
+				UserAgent:  family.UserAgent,
+				CreatedAt:  family.CreatedAt,
+				LastUsedAt: family.LastUsedAt,
+				Current:    familyID == currentSession(c),
+			})
+		}
+
+		sort.Slice(sessions, func(i, j int) bool {
+			return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+		})
+
+		return c.JSON(sessions)
+	}
+}
+
+func revokeSession(redisClient *redis.Client, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := uint(c.Locals("user_id").(float64))
+
+		family, err := loadFamily(c.Context(), redisClient, c.Params("id"))
+		if err != nil || family.UserID != userID {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Session not found",
+			})
+		}
+
+		revokeFamily(c.Context(), redisClient, c.Params("id"))
+		auditor.Record(c, "auth.session_revoke", "user", &userID, map[string]interface{}{
+			"session_id": strings.Clone(c.Params("id")),
+		})
+
+		return c.JSON(fiber.Map{
+			"message": "Session revoked",
+		})
+	}
+}
+
+// revokeAllSessions logs the caller out everywhere. With ?keep_current=true
+// the session making the request survives.
+func revokeAllSessions(redisClient *redis.Client, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := uint(c.Locals("user_id").(float64))
+
+		keep := ""
+		if c.QueryBool("keep_current") {
+			keep = currentSession(c)
+		}
+		revokeUserSessions(c.Context(), redisClient, userID, keep)
+		auditor.Record(c, "auth.sessions_revoke", "user", &userID, map[string]interface{}{
+			"keep_current": keep != "",
+		})
+
+		return c.JSON(fiber.Map{
+			"message": "Sessions revoked",
+		})
+	}
+}
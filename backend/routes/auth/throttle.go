@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"gaming-panel/backend/middleware"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Sliding-window limits on the public auth endpoints. Per-IP limits run as
+// middleware; per-account limits run in the handlers once the account is
+// known.
+var (
+	loginIPLimit          = middleware.Limit{Name: "login:ip", Max: 30, Window: time.Minute}
+	loginAccountLimit     = middleware.Limit{Name: "login:account", Max: 10, Window: time.Minute}
+	registerIPLimit       = middleware.Limit{Name: "register:ip", Max: 5, Window: time.Hour}
+	registerAccountLimit  = middleware.Limit{Name: "register:account", Max: 3, Window: time.Hour}
+	refreshIPLimit        = middleware.Limit{Name: "refresh:ip", Max: 60, Window: time.Minute}
+	refreshAccountLimit   = middleware.Limit{Name: "refresh:account", Max: 30, Window: time.Minute}
+	forgotIPLimit         = middleware.Limit{Name: "password_forgot:ip", Max: 10, Window: time.Hour}
+	forgotAccountLimit    = middleware.Limit{Name: "password_forgot:account", Max: 3, Window: time.Hour}
+	verificationUserLimit = middleware.Limit{Name: "email_resend:user", Max: 3, Window: time.Hour}
+)
+
+// lockoutScope configures progressive lockout for one kind of identifier.
+// Threshold failures within failureWindow lock it out; each lockout within
+// lockoutMemory of the previous one lasts twice as long, up to maxLockout.
+type lockoutScope struct {
+	name      string
+	threshold int64
+}
+
+var (
+	accountLockout = lockoutScope{name: "account", threshold: 5}
+	ipLockout      = lockoutScope{name: "ip", threshold: 20}
+)
+
+const (
+	failureWindow = 15 * time.Minute
+	baseLockout   = time.Minute
+	maxLockout    = time.Hour
+	lockoutMemory = 24 * time.Hour
+)
+
+func (s lockoutScope) key(id string) string {
+	return "lockout:" + s.name + ":" + id
+}
+
+// lockedFor reports how much longer id is locked out.
+func (s lockoutScope) lockedFor(ctx context.Context, redisClient *redis.Client, id string) time.Duration {
+	ttl, err := redisClient.PTTL(ctx, s.key(id)).Result()
+	if err != nil || ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
+// recordFailure counts a failed attempt and locks id out once it reaches
+// the threshold.
+func (s lockoutScope) recordFailure(ctx context.Context, redisClient *redis.Client, id string) {
+	failuresKey := s.key(id) + ":failures"
+	failures, err := redisClient.Incr(ctx, failuresKey).Result()
+	if err != nil {
+		return
+	}
+	if failures == 1 {
+		redisClient.Expire(ctx, failuresKey, failureWindow)
+	}
+	if failures < s.threshold {
+		return
+	}
+
+	levelKey := s.key(id) + ":level"
+	level, err := redisClient.Incr(ctx, levelKey).Result()
+	if err != nil {
+		return
+	}
+	redisClient.Expire(ctx, levelKey, lockoutMemory)
+
+	duration := baseLockout
+	for i := int64(1); i < level && duration < maxLockout; i++ {
+		duration *= 2
+	}
+	if duration > maxLockout {
+		duration = maxLockout
+	}
+
+	pipe := redisClient.TxPipeline()
+	pipe.Set(ctx, s.key(id), 1, duration)
+	pipe.Del(ctx, failuresKey)
+	pipe.Exec(ctx)
+}
+
+// clear forgets id's failures and past lockouts.
+func (s lockoutScope) clear(ctx context.Context, redisClient *redis.Client, id string) {
+	redisClient.Del(ctx, s.key(id)+":failures", s.key(id)+":level")
+}
+
+// accountID is how an account is identified for limits and lockouts.
+func accountID(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginLockedFor reports how much longer logins for account from ip are
+// locked out.
+func loginLockedFor(ctx context.Context, redisClient *redis.Client, account, ip string) time.Duration {
+	wait := accountLockout.lockedFor(ctx, redisClient, account)
+	if ipWait := ipLockout.lockedFor(ctx, redisClient, ip); ipWait > wait {
+		wait = ipWait
+	}
+	return wait
+}
+
+func recordLoginFailure(ctx context.Context, redisClient *redis.Client, account, ip string) {
+	accountLockout.recordFailure(ctx, redisClient, account)
+	ipLockout.recordFailure(ctx, redisClient, ip)
+}
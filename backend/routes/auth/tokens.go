@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gaming-panel/backend/config"
+	"gaming-panel/backend/middleware"
+	"gaming-panel/backend/models"
+	"gaming-panel/backend/websocket/hub"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// refreshTokenPrefix marks refresh tokens so they are recognisable in logs
+// and can't be confused with API keys.
+const refreshTokenPrefix = "rt_"
+
+var (
+	errRefreshTokenInvalid = errors.New("invalid refresh token")
+	errRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+// refreshTokenRecord is what Redis stores for each live refresh token, keyed
+// by the token's hash. Every token belongs to a family started by a login;
+// each refresh replaces the family's token with a new one.
+type refreshTokenRecord struct {
+	UserID   uint   `json:"user_id"`
+	FamilyID string `json:"family_id"`
+}
+
+// refreshFamily tracks one login session across rotations.
+type refreshFamily struct {
+	UserID     uint      `json:"user_id"`
+	TokenHash  string    `json:"token_hash"` // the family's current token
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+}
+
+func refreshTokenKey(hash string) string {
+	return "refresh:" + hash
+}
+
+func usedRefreshTokenKey(hash string) string {
+	return "refresh:used:" + hash
+}
+
+func refreshFamilyKey(familyID string) string {
+	return middleware.SessionKey(familyID)
+}
+
+func userFamiliesKey(userID uint) string {
+	return fmt.Sprintf("user:%d:refresh_families", userID)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(bytes int) (string, error) {
+	buf := make([]byte, bytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// issueAccessToken signs a short-lived JWT for user within a session.
+func issueAccessToken(cfg *config.Config, user models.User, familyID string) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": user.ID,
+		"email":   user.Email,
+		"role_id": user.RoleID,
+		"sid":     familyID,
+		"iat":     now.Unix(),
+		"exp":     now.Add(time.Duration(cfg.JWTExpiration) * time.Minute).Unix(),
+	})
+	return token.SignedString([]byte(cfg.JWTSecret))
+}
+
+// startSession creates a refresh token family for a fresh login and returns
+// its first refresh token.
+func startSession(ctx context.Context, redisClient *redis.Client, cfg *config.Config, userID uint, ip, userAgent string) (refreshToken, familyID string, err error) {
+	familyID, err = randomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	family := refreshFamily{
+		UserID:     userID,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		IP:         ip,
+		UserAgent:  userAgent,
+	}
+
+	if err := redisClient.SAdd(ctx, userFamiliesKey(userID), familyID).Err(); err != nil {
+		return "", "", err
+	}
+
+	refreshToken, err = storeRefreshToken(ctx, redisClient, cfg, familyID, &family)
+	if err != nil {
+		return "", "", err
+	}
+	return refreshToken, familyID, nil
+}
+
+// storeRefreshToken mints a new token for the family and saves both.
+func storeRefreshToken(ctx context.Context, redisClient *redis.Client, cfg *config.Config, familyID string, family *refreshFamily) (string, error) {
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	token := refreshTokenPrefix + secret
+	family.TokenHash = hashToken(token)
+
+	record, err := json.Marshal(refreshTokenRecord{UserID: family.UserID, FamilyID: familyID})
+	if err != nil {
+		return "", err
+	}
+	encodedFamily, err := json.Marshal(family)
+	if err != nil {
+		return "", err
+	}
+
+	ttl := time.Duration(cfg.RefreshTokenExpiration) * time.Hour
+	pipe := redisClient.TxPipeline()
+	pipe.Set(ctx, refreshTokenKey(family.TokenHash), record, ttl)
+	pipe.Set(ctx, refreshFamilyKey(familyID), encodedFamily, ttl)
+	// The index outlives every family in it
+	pipe.Expire(ctx, userFamiliesKey(family.UserID), ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// rotateRefreshToken consumes a refresh token and issues its replacement.
+// Presenting a token that was already rotated out means it leaked, so the
+// whole family is revoked.
+func rotateRefreshToken(ctx context.Context, redisClient *redis.Client, cfg *config.Config, token, ip, userAgent string) (newToken string, userID uint, familyID string, err error) {
+	hash := hashToken(token)
+	ttl := time.Duration(cfg.RefreshTokenExpiration) * time.Hour
+
+	// GETDEL makes sure only one caller can redeem the token
+	raw, err := redisClient.GetDel(ctx, refreshTokenKey(hash)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		if reusedFamily, err := redisClient.Get(ctx, usedRefreshTokenKey(hash)).Result(); err == nil {
+			revokeFamily(ctx, redisClient, reusedFamily)
+			return "", 0, "", errRefreshTokenReused
+		}
+		return "", 0, "", errRefreshTokenInvalid
+	}
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	var record refreshTokenRecord
+	if err := json.Unmarshal(raw, &record); err != nil {
+		return "", 0, "", errRefreshTokenInvalid
+	}
+
+	family, err := loadFamily(ctx, redisClient, record.FamilyID)
+	if err != nil || family.TokenHash != hash {
+		return "", 0, "", errRefreshTokenInvalid
+	}
+
+	// Remember the spent token for as long as it could have been used
+	redisClient.Set(ctx, usedRefreshTokenKey(hash), record.FamilyID, ttl)
+
+	family.LastUsedAt = time.Now()
+	family.IP = ip
+	family.UserAgent = userAgent
+	newToken, err = storeRefreshToken(ctx, redisClient, cfg, record.FamilyID, &family)
+	if err != nil {
+		return "", 0, "", err
+	}
+	return newToken, record.UserID, record.FamilyID, nil
+}
+
+func loadFamily(ctx context.Context, redisClient *redis.Client, familyID string) (refreshFamily, error) {
+	var family refreshFamily
+	raw, err := redisClient.Get(ctx, refreshFamilyKey(familyID)).Bytes()
+	if err != nil {
+		return family, err
+	}
+	err = json.Unmarshal(raw, &family)
+	return family, err
+}
+
+// revokeFamily ends a session: its current refresh token stops working, the
+// family is forgotten and its WebSocket connections are closed.
+func revokeFamily(ctx context.Context, redisClient *redis.Client, familyID string) {
+	hub.PublishRevocation(ctx, redisClient, hub.Revocation{SessionID: familyID})
+
+	family, err := loadFamily(ctx, redisClient, familyID)
+	if err != nil {
+		return
+	}
+
+	pipe := redisClient.TxPipeline()
+	pipe.Del(ctx, refreshTokenKey(family.TokenHash), refreshFamilyKey(familyID))
+	pipe.SRem(ctx, userFamiliesKey(family.UserID), familyID)
+	pipe.Exec(ctx)
+}
+
+// lookupRefreshToken returns the record of a live refresh token.
+func lookupRefreshToken(ctx context.Context, redisClient *redis.Client, token string) (refreshTokenRecord, bool) {
+	var record refreshTokenRecord
+	raw, err := redisClient.Get(ctx, refreshTokenKey(hashToken(token))).Bytes()
+	if err != nil {
+		return record, false
+	}
+	return record, json.Unmarshal(raw, &record) == nil
+}
+
+// revokeUserSessions ends every session a user has, except keep.
+func revokeUserSessions(ctx context.Context, redisClient *redis.Client, userID uint, keep string) {
+	families, err := redisClient.SMembers(ctx, userFamiliesKey(userID)).Result()
+	if err != nil {
+		return
+	}
+	for _, familyID := range families {
+		if familyID != keep {
+			revokeFamily(ctx, redisClient, familyID)
+			// Sessions that expired on their own are still listed
+			redisClient.SRem(ctx, userFamiliesKey(userID), familyID)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gaming-panel/backend/audit"
+	"gaming-panel/backend/config"
+	"gaming-panel/backend/middleware"
+	"gaming-panel/backend/models"
+	"gaming-panel/backend/totp"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	// challengeTTL is how long a user has to enter their code after their
+	// password was accepted
+	challengeTTL         = 5 * time.Minute
+	maxChallengeAttempts = 5
+
+	enrollmentTTL     = 10 * time.Minute
+	recoveryCodeCount = 10
+)
+
+func challengeKey(hash string) string {
+	return "2fa:challenge:" + hash
+}
+
+func enrollmentKey(userID uint) string {
+	return fmt.Sprintf("2fa:enroll:%d", userID)
+}
+
+func usedStepKey(userID uint, step int64) string {
+	return fmt.Sprintf("2fa:used:%d:%d", userID, step)
+}
+
+// normalizeRecoveryCode makes codes comparable however they were typed.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// checkTOTP validates a code and makes sure it can't be replayed.
+func checkTOTP(ctx context.Context, redisClient *redis.Client, userID uint, secret, code string) bool {
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return false
+	}
+
+	// Codes stay valid for up to three steps, so remember them for longer
+	fresh, err := redisClient.SetNX(ctx, usedStepKey(userID, step), 1, 4*totp.Period).Result()
+	return err == nil && fresh
+}
+
+// useRecoveryCode spends one of the user's recovery codes.
+func useRecoveryCode(db *gorm.DB, userID uint, code string) bool {
+	result := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// verifySecondFactor accepts either a TOTP code or a recovery code.
+func verifySecondFactor(ctx context.Context, db *gorm.DB, redisClient *redis.Client, user models.User, code, recoveryCode string) bool {
+	if user.TOTPEnabledAt == nil {
+		return false
+	}
+	if recoveryCode != "" {
+		return useRecoveryCode(db, user.ID, recoveryCode)
+	}
+	return checkTOTP(ctx, redisClient, user.ID, user.TOTPSecret, code)
+}
+
+// generateRecoveryCodes replaces the user's recovery codes and returns the
+// new ones in plain text.
+func generateRecoveryCodes(db *gorm.DB, userID uint) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	records := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		raw, err := randomToken(5)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = raw[:5] + "-" + raw[5:]
+		records[i] = models.RecoveryCode{UserID: userID, CodeHash: hashToken(raw)}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&records).Error
+	})
+	return codes, err
+}
+
+// startChallenge is called instead of issuing tokens when the user has
+// two-factor enabled. The challenge token proves the password was right.
+func startChallenge(c *fiber.Ctx, redisClient *redis.Client, user models.User) error {
+	token, err := randomToken(32)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start two-factor challenge",
+		})
+	}
+
+	if err := redisClient.Set(c.Context(), challengeKey(hashToken(token)), user.ID, challengeTTL).Err(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to start two-factor challenge",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"two_factor_required": true,
+		"challenge_token":     token,
+		"expires_in":          int(challengeTTL.Seconds()),
+	})
+}
+
+// verifyChallenge finishes a login started with a password by checking the
+// second factor.
+func verifyChallenge(db *gorm.DB, redisClient *redis.Client, auditor *audit.Logger, cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			ChallengeToken string `json:"challenge_token"`
+			Code           string `json:"code"`
+			RecoveryCode   string `json:"recovery_code"`
+		}
+
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		key := challengeKey(hashToken(req.ChallengeToken))
+		userID, err := redisClient.Get(c.Context(), key).Uint64()
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Challenge expired; log in again",
+			})
+		}
+
+		var user models.User
+		if err := db.Preload("Role").First(&user, uint(userID)).Error; err != nil {
+			redisClient.Del(c.Context(), key)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Challenge expired; log in again",
+			})
+		}
+
+		account := accountID(user.Email)
+		if wait := accountLockout.lockedFor(c.Context(), redisClient, account); wait > 0 {
+			return middleware.TooManyRequests(c, wait, "Too many failed login attempts, try again later")
+		}
+
+		if !verifySecondFactor(c.Context(), db, redisClient, user, req.Code, req.RecoveryCode) {
+			recordLoginFailure(c.Context(), redisClient, account, c.IP())
+			auditor.RecordAs(c, &user.ID, "auth.login_failed", "user", &user.ID, map[string]interface{}{
+				"email":  user.Email,
+				"reason": "invalid_second_factor",
+			})
+			attempts, _ := redisClient.Incr(c.Context(), key+":attempts").Result()
+			redisClient.Expire(c.Context(), key+":attempts", challengeTTL)
+			if attempts >= maxChallengeAttempts {
+				redisClient.Del(c.Context(), key, key+":attempts")
+			}
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid two-factor code",
+			})
+		}
+
+		redisClient.Del(c.Context(), key, key+":attempts")
+
+		return completeLogin(c, db, redisClient, auditor, cfg, user, "two_factor")
+	}
+}
+
+// enrollTwoFactor starts TOTP enrolment. The secret only takes effect once a
+// code from it is confirmed.
+func enrollTwoFactor(db *gorm.DB, redisClient *redis.Client, cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("user_id").(float64)
+
+		var user models.User
+		if err := db.First(&user, uint(userID)).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+
+		if user.TOTPEnabledAt != nil {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Two-factor authentication is already enabled",
+			})
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to generate secret",
+			})
+		}
+
+		if err := redisClient.Set(c.Context(), enrollmentKey(user.ID), secret, enrollmentTTL).Err(); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to start enrolment",
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"secret":      secret,
+			"otpauth_uri": totp.URI(cfg.TOTPIssuer, user.Email, secret),
+			"expires_in":  int(enrollmentTTL.Seconds()),
+		})
+	}
+}
+
+// confirmTwoFactor enables TOTP once the user proves their authenticator
+// works, and hands out recovery codes.
+func confirmTwoFactor(db *gorm.DB, redisClient *redis.Client, authz *middleware.Authorizer, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := uint(c.Locals("user_id").(float64))
+
+		var req struct {
+			Code string `json:"code"`
+		}
+
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		secret, err := redisClient.Get(c.Context(), enrollmentKey(userID)).Result()
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "No enrolment in progress; start again",
+			})
+		}
+
+		if !checkTOTP(c.Context(), redisClient, userID, secret, req.Code) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid two-factor code",
+			})
+		}
+
+		now := time.Now()
+		result := db.Model(&models.User{}).
+			Where("id = ? AND totp_enabled_at IS NULL", userID).
+			Updates(map[string]interface{}{
+				"totp_secret":     secret,
+				"totp_enabled_at": now,
+			})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Two-factor authentication is already enabled",
+			})
+		}
+		redisClient.Del(c.Context(), enrollmentKey(userID))
+		authz.InvalidateUser(c.Context(), userID)
+		auditor.Record(c, "auth.2fa_enable", "user", &userID, nil)
+
+		codes, err := generateRecoveryCodes(db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Two-factor enabled, but recovery codes could not be generated",
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"message":        "Two-factor authentication enabled",
+			"recovery_codes": codes,
+		})
+	}
+}
+
+// disableTwoFactor turns TOTP off after checking the password and a second
+// factor. Members of roles that require two-factor can't turn it off.
+func disableTwoFactor(db *gorm.DB, redisClient *redis.Client, authz *middleware.Authorizer, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := uint(c.Locals("user_id").(float64))
+
+		var req struct {
+			Password     string `json:"password"`
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		var user models.User
+		if err := db.Preload("Role").First(&user, userID).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+
+		if user.Role.RequireTwoFactor {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Your role requires two-factor authentication",
+			})
+		}
+
+		if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil ||
+			!verifySecondFactor(c.Context(), db, redisClient, user, req.Code, req.RecoveryCode) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid password or two-factor code",
+			})
+		}
+
+		if err := ResetTwoFactor(db, user.ID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to disable two-factor authentication",
+			})
+		}
+		authz.InvalidateUser(c.Context(), user.ID)
+		auditor.Record(c, "auth.2fa_disable", "user", &user.ID, nil)
+
+		return c.JSON(fiber.Map{
+			"message": "Two-factor authentication disabled",
+		})
+	}
+}
+
+// regenerateRecoveryCodes replaces all recovery codes, e.g. after the user
+// ran low.
+func regenerateRecoveryCodes(db *gorm.DB, redisClient *redis.Client, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := uint(c.Locals("user_id").(float64))
+
+		var req struct {
+			Code string `json:"code"`
+		}
+
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		var user models.User
+		if err := db.First(&user, userID).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+
+		if !verifySecondFactor(c.Context(), db, redisClient, user, req.Code, "") {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid two-factor code",
+			})
+		}
+
+		codes, err := generateRecoveryCodes(db, user.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to generate recovery codes",
+			})
+		}
+		auditor.Record(c, "auth.recovery_codes_regenerate", "user", &user.ID, nil)
+
+		return c.JSON(fiber.Map{
+			"recovery_codes": codes,
+		})
+	}
+}
+
+// ResetTwoFactor removes a user's TOTP secret and recovery codes. Callers
+// must invalidate the user's cached access afterwards.
+func ResetTwoFactor(db *gorm.DB, userID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"totp_secret":     "",
+			"totp_enabled_at": nil,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("user not found")
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+	})
+}
@@ -0,0 +1,103 @@
+// Package daemon serves the endpoints node daemons call. They authenticate
+// with node tokens rather than user credentials.
+package daemon
+
+import (
+	"strings"
+	"time"
+
+	"gaming-panel/backend/audit"
+	"gaming-panel/backend/middleware"
+	"gaming-panel/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// enrollIPLimit slows down guessing enrollment tokens.
+var enrollIPLimit = middleware.Limit{Name: "daemon_enroll:ip", Max: 10, Window: time.Hour}
+
+func SetupDaemonRoutes(router fiber.Router, db *gorm.DB, redisClient *redis.Client, auditor *audit.Logger) {
+	router.Post("/enroll", middleware.RateLimit(redisClient, enrollIPLimit, middleware.ByIP), enroll(db, auditor))
+	router.Get("/node", middleware.RequireNode(db), getNode(db))
+}
+
+// enroll exchanges a node's one-time enrollment token for its node token.
+// Enrolling again replaces the node token, so a daemon that was reinstalled
+// locks out the old install.
+func enroll(db *gorm.DB, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			Token string `json:"token"`
+		}
+
+		if err := c.BodyParser(&req); err != nil || !strings.HasPrefix(req.Token, middleware.EnrollmentTokenPrefix) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "An enrollment token is required",
+			})
+		}
+
+		enrollmentHash := middleware.HashAPIKey(req.Token)
+		var node models.Node
+		if err := db.Where("enrollment_token_hash = ? AND enrollment_expires_at > ?", enrollmentHash, time.Now()).
+			First(&node).Error; err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Enrollment token is invalid or has expired",
+			})
+		}
+
+		token, err := middleware.NewNodeToken(middleware.NodeTokenPrefix)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to enroll node",
+			})
+		}
+
+		// Matching on the enrollment token makes it single use even when two
+		// daemons present it at once
+		now := time.Now()
+		result := db.Model(&models.Node{}).
+			Where("id = ? AND enrollment_token_hash = ?", node.ID, enrollmentHash).
+			Updates(map[string]interface{}{
+				"token_hash":            middleware.HashAPIKey(token),
+				"enrollment_token_hash": "",
+				"enrollment_expires_at": nil,
+				"enrolled_at":           now,
+			})
+		if result.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to enroll node",
+			})
+		}
+		if result.RowsAffected == 0 {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Enrollment token is invalid or has expired",
+			})
+		}
+
+		auditor.RecordAs(c, nil, "node.enroll", "node", &node.ID, map[string]interface{}{
+			"reenrolled": node.EnrolledAt != nil,
+		})
+
+		return c.JSON(fiber.Map{
+			"node_id": node.ID,
+			"name":    node.Name,
+			"token":   token,
+		})
+	}
+}
+
+// getNode lets a daemon check its credentials and learn which node it is.
+func getNode(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var node models.Node
+		if err := db.First(&node, c.Locals("node_id").(uint)).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Node not found",
+			})
+		}
+
+		return c.JSON(node)
+	}
+}
@@ -1,6 +1,7 @@
 package nodes
 
 import (
+	"gaming-panel/backend/middleware"
 	"gaming-panel/backend/models"
 
 	"github.com/gofiber/fiber/v2"
@@ -8,10 +9,12 @@ import (
 	"gorm.io/gorm"
 )
 
-func SetupNodeRoutes(router fiber.Router, db *gorm.DB, redisClient *redis.Client) {
-	router.Get("/", listNodes(db))
-	router.Get("/:id", getNode(db))
-	router.Get("/:id/status", getNodeStatus(db))
+func SetupNodeRoutes(router fiber.Router, db *gorm.DB, redisClient *redis.Client, authz *middleware.Authorizer) {
+	view := authz.RequirePermission(models.PermissionNodeView)
+
+	router.Get("/", view, listNodes(db))
+	router.Get("/:id", view, getNode(db))
+	router.Get("/:id/status", view, getNodeStatus(db))
 }
 
 func listNodes(db *gorm.DB) fiber.Handler {
@@ -54,11 +57,15 @@ func getNodeStatus(db *gorm.DB) fiber.Handler {
 		}
 
 		return c.JSON(fiber.Map{
-			"status":    node.Status,
-			"used_ram":  node.UsedRAM,
-			"total_ram": node.TotalRAM,
-			"used_cpu":  node.UsedCPU,
-			"total_cpu": node.TotalCPU,
+			"status":            node.Status,
+			"maintenance":       node.Maintenance,
+			"last_heartbeat_at": node.LastHeartbeatAt,
+			"used_ram":          node.UsedRAM,
+			"total_ram":         node.TotalRAM,
+			"used_cpu":          node.UsedCPU,
+			"total_cpu":         node.TotalCPU,
+			"used_disk":         node.UsedDisk,
+			"total_disk":        node.TotalDisk,
 		})
 	}
 }
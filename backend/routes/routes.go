@@ -1,8 +1,13 @@
 package routes
 
 import (
+	"gaming-panel/backend/audit"
 	"gaming-panel/backend/config"
+	"gaming-panel/backend/mailer"
+	"gaming-panel/backend/middleware"
+	"gaming-panel/backend/routes/apikeys"
 	"gaming-panel/backend/routes/auth"
+	"gaming-panel/backend/routes/daemon"
 	"gaming-panel/backend/routes/servers"
 	"gaming-panel/backend/routes/nodes"
 	"gaming-panel/backend/routes/admin"
@@ -13,19 +18,28 @@ import (
 	"gorm.io/gorm"
 )
 
-func SetupRoutes(router fiber.Router, db *gorm.DB, redisClient *redis.Client, wsHub *hub.Hub, cfg *config.Config) {
+func SetupRoutes(router fiber.Router, db *gorm.DB, redisClient *redis.Client, wsHub *hub.Hub, mail mailer.Mailer, auditor *audit.Logger, cfg *config.Config) {
+	authz := middleware.NewAuthorizer(db, redisClient)
+
 	// Auth routes (public)
-	auth.SetupAuthRoutes(router.Group("/auth"), db, redisClient, cfg)
+	auth.SetupAuthRoutes(router.Group("/auth"), db, redisClient, authz, mail, auditor, cfg)
+
+	// Daemon routes, authenticated with node tokens. Registered before the
+	// protected group, whose middleware would otherwise run for them too
+	daemon.SetupDaemonRoutes(router.Group("/daemon"), db, redisClient, auditor)
+
+	// Protected routes, reachable with a session token or an API key
+	api := router.Group("/", middleware.APIKeyAuthMiddleware(db))
 
-	// Protected routes
-	api := router.Group("/", auth.RequireAuth())
+	// API key routes
+	apikeys.SetupAPIKeyRoutes(api.Group("/api-keys"), db, authz, auditor)
 
 	// Server routes
-	servers.SetupServerRoutes(api.Group("/servers"), db, redisClient, wsHub)
+	servers.SetupServerRoutes(api.Group("/servers"), db, redisClient, wsHub, authz, auditor)
 
 	// Node routes
-	nodes.SetupNodeRoutes(api.Group("/nodes"), db, redisClient)
+	nodes.SetupNodeRoutes(api.Group("/nodes"), db, redisClient, authz)
 
 	// Admin routes
-	admin.SetupAdminRoutes(api.Group("/admin"), db, redisClient, cfg)
+	admin.SetupAdminRoutes(api.Group("/admin"), db, redisClient, authz, auditor, cfg)
 }
@@ -0,0 +1,91 @@
+package servers
+
+import (
+	"errors"
+
+	"gaming-panel/backend/middleware"
+	"gaming-panel/backend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// accessAny is passed to findServer when any access to the server will do.
+const accessAny = ""
+
+var (
+	errServerNotFound  = errors.New("server not found")
+	errServerDenied    = errors.New("you do not have permission to perform this action on this server")
+	errNodeMaintenance = errors.New("the server's node is in maintenance, try again later")
+)
+
+// accessibleServers scopes a query on servers to the ones userID owns or has
+// accepted a subuser invite for.
+func accessibleServers(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Where(
+		"(owner_id = ? OR id IN (?))", userID,
+		db.Session(&gorm.Session{NewDB: true}).Model(&models.Subuser{}).
+			Select("server_id").
+			Where("user_id = ? AND accepted_at IS NOT NULL", userID),
+	)
+}
+
+// findServer loads a server by column ("id" or "uuid") for userID. Owners may
+// do anything; subusers need permission key unless it is accessAny. Preloads
+// set on db are kept.
+func findServer(db *gorm.DB, userID uint, column string, value interface{}, key string) (models.Server, error) {
+	var server models.Server
+	if err := accessibleServers(db, userID).Where(column+" = ?", value).First(&server).Error; err != nil {
+		return server, errServerNotFound
+	}
+
+	return server, checkServerPermission(db, userID, server, key)
+}
+
+// checkServerPermission reports whether userID may use permission key on a
+// server they have access to.
+func checkServerPermission(db *gorm.DB, userID uint, server models.Server, key string) error {
+	if server.OwnerID == userID || key == accessAny {
+		return nil
+	}
+
+	var subuser models.Subuser
+	if err := db.Session(&gorm.Session{NewDB: true}).
+		Where("server_id = ? AND user_id = ? AND accepted_at IS NOT NULL", server.ID, userID).
+		First(&subuser).Error; err != nil {
+		return errServerNotFound
+	}
+	if !subuser.Permissions[key] {
+		return errServerDenied
+	}
+	return nil
+}
+
+// checkNodeAvailable refuses work for a server's daemon while its node is in
+// maintenance.
+func checkNodeAvailable(db *gorm.DB, server models.Server) error {
+	var node models.Node
+	if err := db.Session(&gorm.Session{NewDB: true}).Select("maintenance").First(&node, server.NodeID).Error; err != nil {
+		return errServerNotFound
+	}
+	if node.Maintenance {
+		return errNodeMaintenance
+	}
+	return nil
+}
+
+// serverAccessError writes the response for a findServer or
+// checkNodeAvailable error.
+func serverAccessError(c *fiber.Ctx, err error, key string) error {
+	if errors.Is(err, errServerDenied) {
+		return middleware.Forbidden(c, key)
+	}
+	if errors.Is(err, errNodeMaintenance) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "The server's node is in maintenance, try again later",
+		})
+	}
+	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+		"error": "Server not found",
+	})
+}
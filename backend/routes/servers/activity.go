@@ -0,0 +1,36 @@
+package servers
+
+import (
+	"fmt"
+
+	"gaming-panel/backend/audit"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// serverActivity shows the owner the audit log entries about their server,
+// or exports them with ?format=. The IP and user agent are only shown on the
+// owner's own entries, not on those of subusers or admins.
+func serverActivity(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		server, err := ownedServer(c, db)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Server not found",
+			})
+		}
+
+		filter, err := audit.ParseFilter(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		filter.ResourceType = "server"
+		filter.ResourceID = &server.ID
+		filter.ClientOf = &server.OwnerID
+
+		return audit.Serve(c, db, filter, fmt.Sprintf("server-%d-activity", server.ID))
+	}
+}
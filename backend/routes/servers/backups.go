@@ -0,0 +1,207 @@
+package servers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gaming-panel/backend/audit"
+	"gaming-panel/backend/models"
+	"gaming-panel/backend/websocket/hub"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// downloadLinkTTL is how long a signed backup download link stays valid.
+const downloadLinkTTL = 15 * time.Minute
+
+func listBackups(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("user_id").(float64)
+		serverID := c.Params("id")
+
+		server, err := findServer(db, uint(userID), "id", serverID, models.ServerPermissionBackupView)
+		if err != nil {
+			return serverAccessError(c, err, models.ServerPermissionBackupView)
+		}
+
+		var backups []models.Backup
+		if err := db.Where("server_id = ?", server.ID).Order("created_at DESC").Find(&backups).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch backups",
+			})
+		}
+
+		return c.JSON(backups)
+	}
+}
+
+func downloadBackup(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("user_id").(float64)
+		serverID := c.Params("id")
+
+		server, err := findServer(db.Preload("Node"), uint(userID), "id", serverID, models.ServerPermissionBackupView)
+		if err != nil {
+			return serverAccessError(c, err, models.ServerPermissionBackupView)
+		}
+
+		var backup models.Backup
+		if err := db.Where("id = ? AND server_id = ?", c.Params("backup_id"), server.ID).First(&backup).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Backup not found",
+			})
+		}
+
+		if !backup.IsSuccess {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Backup is not available for download",
+			})
+		}
+
+		// The archive lives on the node, so hand out a short-lived link the
+		// daemon can verify. It is signed with the hash of the node's token,
+		// which only the panel and that node know.
+		if server.Node.TokenHash == "" {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "The server's node has not enrolled",
+			})
+		}
+		expires := time.Now().Add(downloadLinkTTL).Unix()
+		signature := signBackupDownload(server.Node.TokenHash, server.UUID, backup.ID, expires)
+
+		return c.JSON(fiber.Map{
+			"url": fmt.Sprintf("%s://%s:%d/backups/%s/%d?expires=%d&signature=%s",
+				server.Node.Scheme, server.Node.Hostname, server.Node.Port, server.UUID, backup.ID, expires, signature),
+			"expires_at": time.Unix(expires, 0),
+		})
+	}
+}
+
+func restoreBackup(db *gorm.DB, redisClient *redis.Client, wsHub *hub.Hub, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("user_id").(float64)
+		serverID := c.Params("id")
+
+		var req struct {
+			Truncate bool `json:"truncate"`
+		}
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "Invalid request body",
+				})
+			}
+		}
+
+		server, err := findServer(db.Preload("Allocation"), uint(userID), "id", serverID, models.ServerPermissionBackupManage)
+		if err != nil {
+			return serverAccessError(c, err, models.ServerPermissionBackupManage)
+		}
+		if err := checkNodeAvailable(db, server); err != nil {
+			return serverAccessError(c, err, models.ServerPermissionBackupManage)
+		}
+
+		var backup models.Backup
+		if err := db.Where("id = ? AND server_id = ?", c.Params("backup_id"), server.ID).First(&backup).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Backup not found",
+			})
+		}
+
+		if !backup.IsSuccess {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Only completed backups can be restored",
+			})
+		}
+
+		// The daemon restarts the server afterwards, so it needs the full
+		// configuration in case the container has to be recreated
+		payload, err := daemonPayload(server, fiber.Map{
+			"backup_id": backup.ID,
+			"truncate":  req.Truncate,
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to build server configuration",
+			})
+		}
+
+		publishJob(c.Context(), db, redisClient, server.NodeID, "server:restore", payload)
+		auditor.Record(c, "backup.restore", "server", &server.ID, map[string]interface{}{
+			"backup_id": backup.ID,
+			"truncate":  req.Truncate,
+		})
+
+		wsHub.BroadcastToServer(server.UUID, map[string]interface{}{
+			"type":      "backup.restore",
+			"backup_id": backup.ID,
+			"stage":     "queued",
+		})
+
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"message": "Backup restore queued",
+		})
+	}
+}
+
+func deleteBackup(db *gorm.DB, redisClient *redis.Client, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("user_id").(float64)
+		serverID := c.Params("id")
+
+		server, err := findServer(db, uint(userID), "id", serverID, models.ServerPermissionBackupManage)
+		if err != nil {
+			return serverAccessError(c, err, models.ServerPermissionBackupManage)
+		}
+		if err := checkNodeAvailable(db, server); err != nil {
+			return serverAccessError(c, err, models.ServerPermissionBackupManage)
+		}
+
+		var backup models.Backup
+		if err := db.Where("id = ? AND server_id = ?", c.Params("backup_id"), server.ID).First(&backup).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Backup not found",
+			})
+		}
+
+		if backup.CompletedAt == nil {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Backup is still in progress",
+			})
+		}
+
+		if err := db.Delete(&backup).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete backup",
+			})
+		}
+
+		// Remove the archive from the node
+		payload, _ := json.Marshal(fiber.Map{
+			"server_id": server.ID,
+			"uuid":      server.UUID,
+			"backup_id": backup.ID,
+		})
+		publishJob(c.Context(), db, redisClient, server.NodeID, "server:backup:delete", string(payload))
+		auditor.Record(c, "backup.delete", "server", &server.ID, map[string]interface{}{
+			"backup_id": backup.ID,
+		})
+
+		return c.JSON(fiber.Map{
+			"message": "Backup deleted",
+		})
+	}
+}
+
+// signBackupDownload must stay in sync with the daemon, which verifies the links.
+func signBackupDownload(secret, serverUUID string, backupID uint, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s:%d:%d", serverUUID, backupID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
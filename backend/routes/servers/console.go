@@ -0,0 +1,143 @@
+package servers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"gaming-panel/backend/audit"
+	"gaming-panel/backend/middleware"
+	"gaming-panel/backend/models"
+	"gaming-panel/backend/websocket/hub"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// maxCommandLength bounds a single console command.
+const maxCommandLength = 1024
+
+var errInvalidCommand = errors.New("command must be a single non-empty line of at most 1024 characters")
+
+func sendCommand(db *gorm.DB, redisClient *redis.Client, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("user_id").(float64)
+		serverID := c.Params("id")
+
+		var req struct {
+			Command string `json:"command"`
+		}
+
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		server, err := findServer(db, uint(userID), "id", serverID, models.ServerPermissionConsole)
+		if err != nil {
+			return serverAccessError(c, err, models.ServerPermissionConsole)
+		}
+
+		if err := dispatchCommand(c.Context(), db, redisClient, auditor, server, uint(userID), req.Command, c.IP(), c.Get("User-Agent")); err != nil {
+			if errors.Is(err, errInvalidCommand) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+			if errors.Is(err, errNodeMaintenance) {
+				return serverAccessError(c, err, models.ServerPermissionConsole)
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to send command",
+			})
+		}
+
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"message": "Command sent",
+		})
+	}
+}
+
+// subscribeHandler lets WebSocket clients watch servers they own or may see
+// the console of.
+func subscribeHandler(db *gorm.DB, authz *middleware.Authorizer) hub.SubscribeHandler {
+	return func(userID uint, serverUUID string) error {
+		if err := requireHubPermission(authz, userID, models.PermissionServerView); err != nil {
+			return err
+		}
+
+		_, err := findServer(db, userID, "uuid", serverUUID, models.ServerPermissionConsole)
+		return err
+	}
+}
+
+// commandHandler lets WebSocket clients send console commands for servers
+// they own or have console access to.
+func commandHandler(db *gorm.DB, redisClient *redis.Client, authz *middleware.Authorizer, auditor *audit.Logger) hub.CommandHandler {
+	return func(cmd hub.Command) error {
+		if err := requireHubPermission(authz, cmd.UserID, models.PermissionServerManage); err != nil {
+			return err
+		}
+
+		server, err := findServer(db, cmd.UserID, "uuid", cmd.ServerUUID, models.ServerPermissionConsole)
+		if err != nil {
+			return err
+		}
+
+		return dispatchCommand(context.Background(), db, redisClient, auditor, server, cmd.UserID, cmd.Command, cmd.IP, cmd.UserAgent)
+	}
+}
+
+// requireHubPermission is RequirePermission for WebSocket messages, which
+// don't pass through the route middleware.
+func requireHubPermission(authz *middleware.Authorizer, userID uint, key string) error {
+	permissions, err := authz.Permissions(context.Background(), userID)
+	if err != nil {
+		return errors.New("failed to resolve permissions")
+	}
+	if !permissions.Has(key) {
+		return errors.New("you do not have permission to perform this action")
+	}
+	return nil
+}
+
+// dispatchCommand validates a console command, forwards it to the daemon and
+// records it in the audit log.
+func dispatchCommand(ctx context.Context, db *gorm.DB, redisClient *redis.Client, auditor *audit.Logger, server models.Server, userID uint, command, ip, userAgent string) error {
+	command = strings.TrimRight(command, "\r\n")
+	if command == "" || len(command) > maxCommandLength || strings.ContainsAny(command, "\r\n") {
+		return errInvalidCommand
+	}
+	if err := checkNodeAvailable(db, server); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(fiber.Map{
+		"server_id": server.ID,
+		"command":   command,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := publishJob(ctx, db, redisClient, server.NodeID, "server:command", string(payload)); err != nil {
+		return err
+	}
+
+	auditor.Log(models.AuditLog{
+		UserID:       &userID,
+		Action:       "server.command",
+		ResourceType: "server",
+		ResourceID:   &server.ID,
+		IP:           strings.Clone(ip),
+		UserAgent:    strings.Clone(userAgent),
+		Metadata: map[string]interface{}{
+			"command": command,
+		},
+	})
+
+	return nil
+}
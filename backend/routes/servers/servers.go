@@ -1,6 +1,16 @@
 package servers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"gaming-panel/backend/audit"
+	"gaming-panel/backend/middleware"
 	"gaming-panel/backend/models"
 	"gaming-panel/backend/websocket/hub"
 
@@ -9,16 +19,43 @@ import (
 	"gorm.io/gorm"
 )
 
-func SetupServerRoutes(router fiber.Router, db *gorm.DB, redisClient *redis.Client, wsHub *hub.Hub) {
-	router.Get("/", listServers(db))
-	router.Get("/:id", getServer(db))
-	router.Post("/", createServer(db))
-	router.Post("/:id/start", startServer(db, redisClient, wsHub))
-	router.Post("/:id/stop", stopServer(db, redisClient, wsHub))
-	router.Post("/:id/restart", restartServer(db, redisClient, wsHub))
-	router.Get("/:id/status", getServerStatus(db))
-	router.Post("/:id/backup", createBackup(db, redisClient))
-	router.Delete("/:id", deleteServer(db))
+// Per-user limits on actions that make the daemon do heavy work.
+var (
+	powerLimit  = middleware.Limit{Name: "server:power", Max: 10, Window: time.Minute}
+	backupLimit = middleware.Limit{Name: "server:backup", Max: 5, Window: 10 * time.Minute}
+)
+
+func SetupServerRoutes(router fiber.Router, db *gorm.DB, redisClient *redis.Client, wsHub *hub.Hub, authz *middleware.Authorizer, auditor *audit.Logger) {
+	view := authz.RequirePermission(models.PermissionServerView)
+	manage := authz.RequirePermission(models.PermissionServerManage)
+	power := middleware.RateLimit(redisClient, powerLimit, middleware.ByUser)
+	backup := middleware.RateLimit(redisClient, backupLimit, middleware.ByUser)
+
+	router.Get("/", view, listServers(db))
+	router.Get("/permissions", view, listServerPermissions())
+	router.Get("/invites", view, listInvites(db))
+	router.Post("/invites/accept", view, acceptInvite(db, auditor))
+	router.Get("/:id", view, getServer(db))
+	router.Post("/", manage, createServer(db, auditor))
+	router.Post("/:id/start", manage, power, startServer(db, redisClient, wsHub, auditor))
+	router.Post("/:id/stop", manage, power, stopServer(db, redisClient, wsHub, auditor))
+	router.Post("/:id/restart", manage, power, restartServer(db, redisClient, wsHub, auditor))
+	router.Get("/:id/status", view, getServerStatus(db))
+	router.Get("/:id/activity", view, serverActivity(db))
+	router.Post("/:id/command", manage, sendCommand(db, redisClient, auditor))
+	router.Post("/:id/backup", manage, backup, createBackup(db, redisClient, auditor))
+	router.Get("/:id/backups", view, listBackups(db))
+	router.Get("/:id/backups/:backup_id/download", view, downloadBackup(db))
+	router.Post("/:id/backups/:backup_id/restore", manage, restoreBackup(db, redisClient, wsHub, auditor))
+	router.Delete("/:id/backups/:backup_id", manage, deleteBackup(db, redisClient, auditor))
+	router.Get("/:id/subusers", manage, listSubusers(db))
+	router.Post("/:id/subusers", manage, inviteSubuser(db, auditor))
+	router.Put("/:id/subusers/:subuser_id", manage, updateSubuser(db, redisClient, auditor))
+	router.Delete("/:id/subusers/:subuser_id", view, revokeSubuser(db, redisClient, auditor))
+	router.Delete("/:id", manage, deleteServer(db, redisClient, auditor))
+
+	wsHub.OnSubscribe(subscribeHandler(db, authz))
+	wsHub.OnCommand(commandHandler(db, redisClient, authz, auditor))
 }
 
 func listServers(db *gorm.DB) fiber.Handler {
@@ -26,7 +63,7 @@ func listServers(db *gorm.DB) fiber.Handler {
 		userID := c.Locals("user_id").(float64)
 		
 		var servers []models.Server
-		if err := db.Where("owner_id = ?", uint(userID)).
+		if err := accessibleServers(db, uint(userID)).
 			Preload("Node").
 			Preload("Allocation").
 			Find(&servers).Error; err != nil {
@@ -44,22 +81,20 @@ func getServer(db *gorm.DB) fiber.Handler {
 		userID := c.Locals("user_id").(float64)
 		serverID := c.Params("id")
 
-		var server models.Server
-		if err := db.Where("id = ? AND owner_id = ?", serverID, uint(userID)).
-			Preload("Node").
-			Preload("Allocation").
-			Preload("Backups").
-			First(&server).Error; err != nil {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"error": "Server not found",
-			})
+		server, err := findServer(db.Preload("Node").Preload("Allocation").Preload("Backups"), uint(userID), "id", serverID, accessAny)
+		if err != nil {
+			return serverAccessError(c, err, accessAny)
+		}
+
+		if checkServerPermission(db, uint(userID), server, models.ServerPermissionBackupView) != nil {
+			server.Backups = nil
 		}
 
 		return c.JSON(server)
 	}
 }
 
-func createServer(db *gorm.DB) fiber.Handler {
+func createServer(db *gorm.DB, auditor *audit.Logger) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("user_id").(float64)
 
@@ -78,6 +113,18 @@ func createServer(db *gorm.DB) fiber.Handler {
 			})
 		}
 
+		var node models.Node
+		if err := db.First(&node, req.NodeID).Error; err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Node not found",
+			})
+		}
+		if node.Maintenance {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Node is in maintenance and not accepting new servers",
+			})
+		}
+
 		// Find available allocation
 		var allocation models.Allocation
 		if err := db.Where("node_id = ? AND assigned = ?", req.NodeID, false).First(&allocation).Error; err != nil {
@@ -108,28 +155,45 @@ func createServer(db *gorm.DB) fiber.Handler {
 		allocation.Assigned = true
 		db.Save(&allocation)
 
+		auditor.Record(c, "server.create", "server", &server.ID, map[string]interface{}{
+			"name":          server.Name,
+			"node_id":       server.NodeID,
+			"allocation_id": allocation.ID,
+			"docker_image":  server.DockerImage,
+		})
+
 		return c.Status(fiber.StatusCreated).JSON(server)
 	}
 }
 
-func startServer(db *gorm.DB, redisClient *redis.Client, wsHub *hub.Hub) fiber.Handler {
+func startServer(db *gorm.DB, redisClient *redis.Client, wsHub *hub.Hub, auditor *audit.Logger) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("user_id").(float64)
 		serverID := c.Params("id")
 
-		var server models.Server
-		if err := db.Where("id = ? AND owner_id = ?", serverID, uint(userID)).First(&server).Error; err != nil {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"error": "Server not found",
+		server, err := findServer(db.Preload("Allocation"), uint(userID), "id", serverID, models.ServerPermissionPower)
+		if err != nil {
+			return serverAccessError(c, err, models.ServerPermissionPower)
+		}
+		if err := checkNodeAvailable(db, server); err != nil {
+			return serverAccessError(c, err, models.ServerPermissionPower)
+		}
+
+		payload, err := daemonPayload(server, nil)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to build server configuration",
 			})
 		}
 
 		// Update status
 		server.Status = models.ServerStatusStarting
-		db.Save(&server)
+		server.StatusError = ""
+		db.Omit("Allocation").Save(&server)
 
 		// Publish to Redis queue for daemon to process
-		redisClient.Publish(c.Context(), "server:start", server.ID)
+		publishJob(c.Context(), db, redisClient, server.NodeID, "server:start", payload)
+		auditor.Record(c, "server.start", "server", &server.ID, nil)
 
 		// Broadcast WebSocket event
 		wsHub.BroadcastToServer(server.UUID, map[string]interface{}{
@@ -144,22 +208,25 @@ func startServer(db *gorm.DB, redisClient *redis.Client, wsHub *hub.Hub) fiber.H
 	}
 }
 
-func stopServer(db *gorm.DB, redisClient *redis.Client, wsHub *hub.Hub) fiber.Handler {
+func stopServer(db *gorm.DB, redisClient *redis.Client, wsHub *hub.Hub, auditor *audit.Logger) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("user_id").(float64)
 		serverID := c.Params("id")
 
-		var server models.Server
-		if err := db.Where("id = ? AND owner_id = ?", serverID, uint(userID)).First(&server).Error; err != nil {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"error": "Server not found",
-			})
+		server, err := findServer(db, uint(userID), "id", serverID, models.ServerPermissionPower)
+		if err != nil {
+			return serverAccessError(c, err, models.ServerPermissionPower)
+		}
+		if err := checkNodeAvailable(db, server); err != nil {
+			return serverAccessError(c, err, models.ServerPermissionPower)
 		}
 
 		server.Status = models.ServerStatusStopping
+		server.StatusError = ""
 		db.Save(&server)
 
-		redisClient.Publish(c.Context(), "server:stop", server.ID)
+		publishJob(c.Context(), db, redisClient, server.NodeID, "server:stop", strconv.FormatUint(uint64(server.ID), 10))
+		auditor.Record(c, "server.stop", "server", &server.ID, nil)
 
 		wsHub.BroadcastToServer(server.UUID, map[string]interface{}{
 			"type":   "server.status",
@@ -173,22 +240,32 @@ func stopServer(db *gorm.DB, redisClient *redis.Client, wsHub *hub.Hub) fiber.Ha
 	}
 }
 
-func restartServer(db *gorm.DB, redisClient *redis.Client, wsHub *hub.Hub) fiber.Handler {
+func restartServer(db *gorm.DB, redisClient *redis.Client, wsHub *hub.Hub, auditor *audit.Logger) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("user_id").(float64)
 		serverID := c.Params("id")
 
-		var server models.Server
-		if err := db.Where("id = ? AND owner_id = ?", serverID, uint(userID)).First(&server).Error; err != nil {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"error": "Server not found",
+		server, err := findServer(db.Preload("Allocation"), uint(userID), "id", serverID, models.ServerPermissionPower)
+		if err != nil {
+			return serverAccessError(c, err, models.ServerPermissionPower)
+		}
+		if err := checkNodeAvailable(db, server); err != nil {
+			return serverAccessError(c, err, models.ServerPermissionPower)
+		}
+
+		payload, err := daemonPayload(server, nil)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to build server configuration",
 			})
 		}
 
 		server.Status = models.ServerStatusStopping
-		db.Save(&server)
+		server.StatusError = ""
+		db.Omit("Allocation").Save(&server)
 
-		redisClient.Publish(c.Context(), "server:restart", server.ID)
+		publishJob(c.Context(), db, redisClient, server.NodeID, "server:restart", payload)
+		auditor.Record(c, "server.restart", "server", &server.ID, nil)
 
 		return c.JSON(fiber.Map{
 			"message": "Server restart command sent",
@@ -201,42 +278,61 @@ func getServerStatus(db *gorm.DB) fiber.Handler {
 		userID := c.Locals("user_id").(float64)
 		serverID := c.Params("id")
 
-		var server models.Server
-		if err := db.Where("id = ? AND owner_id = ?", serverID, uint(userID)).First(&server).Error; err != nil {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"error": "Server not found",
-			})
+		server, err := findServer(db, uint(userID), "id", serverID, accessAny)
+		if err != nil {
+			return serverAccessError(c, err, accessAny)
 		}
 
 		return c.JSON(fiber.Map{
-			"status": server.Status,
-			"uuid":   server.UUID,
+			"status":       server.Status,
+			"status_error": server.StatusError,
+			"uuid":         server.UUID,
 		})
 	}
 }
 
-func createBackup(db *gorm.DB, redisClient *redis.Client) fiber.Handler {
+func createBackup(db *gorm.DB, redisClient *redis.Client, auditor *audit.Logger) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("user_id").(float64)
 		serverID := c.Params("id")
 
-		var server models.Server
-		if err := db.Where("id = ? AND owner_id = ?", serverID, uint(userID)).First(&server).Error; err != nil {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"error": "Server not found",
+		server, err := findServer(db, uint(userID), "id", serverID, models.ServerPermissionBackupManage)
+		if err != nil {
+			return serverAccessError(c, err, models.ServerPermissionBackupManage)
+		}
+		if err := checkNodeAvailable(db, server); err != nil {
+			return serverAccessError(c, err, models.ServerPermissionBackupManage)
+		}
+
+		backup := models.Backup{
+			ServerID: server.ID,
+		}
+		if err := db.Create(&backup).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create backup",
 			})
 		}
 
+		payload, _ := json.Marshal(fiber.Map{
+			"server_id": server.ID,
+			"uuid":      server.UUID,
+			"backup_id": backup.ID,
+		})
+
 		// Queue backup job
-		redisClient.Publish(c.Context(), "server:backup", server.ID)
+		publishJob(c.Context(), db, redisClient, server.NodeID, "server:backup", string(payload))
+		auditor.Record(c, "backup.create", "server", &server.ID, map[string]interface{}{
+			"backup_id": backup.ID,
+		})
 
-		return c.JSON(fiber.Map{
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
 			"message": "Backup job queued",
+			"backup":  backup,
 		})
 	}
 }
 
-func deleteServer(db *gorm.DB) fiber.Handler {
+func deleteServer(db *gorm.DB, redisClient *redis.Client, auditor *audit.Logger) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("user_id").(float64)
 		serverID := c.Params("id")
@@ -256,10 +352,127 @@ func deleteServer(db *gorm.DB) fiber.Handler {
 			db.Save(&allocation)
 		}
 
-		db.Delete(&server)
+		var backups []models.Backup
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			if backups, err = DeleteBackups(tx, server.ID); err != nil {
+				return err
+			}
+			if err := tx.Where("server_id = ?", server.ID).Delete(&models.Subuser{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&server).Error
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to delete server",
+			})
+		}
+
+		PublishDelete(c.Context(), db, redisClient, server, backups)
+
+		auditor.Record(c, "server.delete", "server", &server.ID, map[string]interface{}{
+			"name":    server.Name,
+			"node_id": server.NodeID,
+		})
 
 		return c.JSON(fiber.Map{
 			"message": "Server deleted",
 		})
 	}
 }
+
+// DeleteBackups deletes the rows of a server's backups and returns them, so
+// their archives can be removed by PublishDelete once tx is committed.
+func DeleteBackups(tx *gorm.DB, serverID uint) ([]models.Backup, error) {
+	var backups []models.Backup
+	if err := tx.Where("server_id = ?", serverID).Find(&backups).Error; err != nil {
+		return nil, err
+	}
+	if len(backups) == 0 {
+		return nil, nil
+	}
+	return backups, tx.Delete(&backups).Error
+}
+
+// PublishDelete tells the server's node to remove its container, its data
+// directory and the archives of backups, which DeleteBackups returned. The
+// rows are already gone by then, so nothing is reported back. Failures are
+// logged.
+func PublishDelete(ctx context.Context, db *gorm.DB, redisClient *redis.Client, server models.Server, backups []models.Backup) error {
+	var errs []error
+	for _, backup := range backups {
+		payload, err := json.Marshal(fiber.Map{
+			"server_id": server.ID,
+			"uuid":      server.UUID,
+			"backup_id": backup.ID,
+		})
+		if err == nil {
+			err = publishJob(ctx, db, redisClient, server.NodeID, "server:backup:delete", string(payload))
+		}
+		errs = append(errs, err)
+	}
+
+	payload, err := json.Marshal(fiber.Map{
+		"server_id": server.ID,
+		"uuid":      server.UUID,
+	})
+	if err == nil {
+		err = publishJob(ctx, db, redisClient, server.NodeID, "server:delete", string(payload))
+	}
+	return errors.Join(append(errs, err)...)
+}
+
+// daemonChannel is the Redis channel the daemon of a node takes jobs of kind,
+// such as "server:start", from.
+func daemonChannel(nodeID uint, kind string) string {
+	return fmt.Sprintf("node:%d:%s", nodeID, kind)
+}
+
+// publishJob sends a job to a node's daemon, signed with the node's key so
+// the daemon can tell it came from the panel. Failures are logged as well as
+// returned, since most callers have already answered the request.
+func publishJob(ctx context.Context, db *gorm.DB, redisClient *redis.Client, nodeID uint, kind, payload string) error {
+	var node models.Node
+	err := db.Session(&gorm.Session{NewDB: true}).Select("token_hash").First(&node, nodeID).Error
+	if err == nil {
+		channel := daemonChannel(nodeID, kind)
+		var message string
+		if message, err = middleware.SignNodeMessage(node.TokenHash, channel, payload); err == nil {
+			err = redisClient.Publish(ctx, channel, message).Err()
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to send %s job to node %d: %v", kind, nodeID, err)
+	}
+	return err
+}
+
+// daemonPayload serializes everything the daemon needs to create the
+// server's container if it doesn't exist yet, plus any job-specific fields in
+// extra. The allocation must be loaded.
+func daemonPayload(server models.Server, extra fiber.Map) (string, error) {
+	if server.Allocation.ID == 0 {
+		return "", fmt.Errorf("server %d has no allocation", server.ID)
+	}
+
+	payload := fiber.Map{
+		"server_id":    server.ID,
+		"uuid":         server.UUID,
+		"docker_image": server.DockerImage,
+		"memory_limit": server.MemoryLimit,
+		"cpu_limit":    server.CPULimit,
+		"disk_limit":   server.DiskLimit,
+		"ip":           server.Allocation.IP,
+		"port":         server.Allocation.Port,
+	}
+	for key, value := range extra {
+		payload[key] = value
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
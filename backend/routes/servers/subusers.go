@@ -0,0 +1,368 @@
+package servers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"gaming-panel/backend/audit"
+	"gaming-panel/backend/middleware"
+	"gaming-panel/backend/models"
+	"gaming-panel/backend/websocket/hub"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// inviteTTL is how long a subuser invite can be accepted.
+const inviteTTL = 7 * 24 * time.Hour
+
+func hashInviteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newInviteToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// validateServerPermissions checks every key against the server permission
+// catalogue and drops the ones that are not granted.
+func validateServerPermissions(permissions models.Permissions) (models.Permissions, error) {
+	granted := models.Permissions{}
+	for key, value := range permissions {
+		if _, ok := models.ServerPermissionCatalogue[key]; !ok {
+			return nil, fmt.Errorf("unknown server permission %q", key)
+		}
+		if value {
+			granted[key] = true
+		}
+	}
+	if len(granted) == 0 {
+		return nil, fmt.Errorf("at least one permission must be granted")
+	}
+	return granted, nil
+}
+
+// ownedServer loads a server only its owner may manage subusers for.
+func ownedServer(c *fiber.Ctx, db *gorm.DB) (models.Server, error) {
+	userID := c.Locals("user_id").(float64)
+
+	var server models.Server
+	err := db.Where("id = ? AND owner_id = ?", c.Params("id"), uint(userID)).First(&server).Error
+	return server, err
+}
+
+func listServerPermissions() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(models.ServerPermissionCatalogue)
+	}
+}
+
+func listSubusers(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		server, err := ownedServer(c, db)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Server not found",
+			})
+		}
+
+		var subusers []models.Subuser
+		if err := db.Where("server_id = ?", server.ID).Preload("User").Order("id").Find(&subusers).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch subusers",
+			})
+		}
+
+		return c.JSON(subusers)
+	}
+}
+
+// inviteSubuser creates an invite for an email address. The invite token is
+// only returned here, for the owner to pass on.
+func inviteSubuser(db *gorm.DB, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("user_id").(float64)
+
+		var req struct {
+			Email       string             `json:"email"`
+			Permissions models.Permissions `json:"permissions"`
+		}
+
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		server, err := ownedServer(c, db)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Server not found",
+			})
+		}
+
+		req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+		if _, err := mail.ParseAddress(req.Email); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid email address",
+			})
+		}
+
+		permissions, err := validateServerPermissions(req.Permissions)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		var owner models.User
+		if db.First(&owner, server.OwnerID).Error == nil && strings.EqualFold(owner.Email, req.Email) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "You already own this server",
+			})
+		}
+
+		var existing int64
+		db.Model(&models.Subuser{}).
+			Where("server_id = ? AND email = ? AND (accepted_at IS NOT NULL OR expires_at > ?)", server.ID, req.Email, time.Now()).
+			Count(&existing)
+		if existing > 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "This email already has access or a pending invite",
+			})
+		}
+
+		token, err := newInviteToken()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create invite",
+			})
+		}
+
+		subuser := models.Subuser{
+			ServerID:        server.ID,
+			Email:           req.Email,
+			Permissions:     permissions,
+			InvitedByID:     uint(userID),
+			InviteTokenHash: hashInviteToken(token),
+			ExpiresAt:       time.Now().Add(inviteTTL),
+		}
+
+		if err := db.Create(&subuser).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to create invite",
+			})
+		}
+
+		auditor.Record(c, "subuser.invite", "server", &server.ID, map[string]interface{}{
+			"subuser_id":  subuser.ID,
+			"email":       subuser.Email,
+			"permissions": subuser.Permissions,
+		})
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"subuser":      subuser,
+			"invite_token": token,
+		})
+	}
+}
+
+// updateSubuser replaces a subuser's permissions. Taking away console access
+// also closes their WebSocket connections watching the server.
+func updateSubuser(db *gorm.DB, redisClient *redis.Client, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			Permissions models.Permissions `json:"permissions"`
+		}
+
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+
+		server, err := ownedServer(c, db)
+		if err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Server not found",
+			})
+		}
+
+		permissions, err := validateServerPermissions(req.Permissions)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		var subuser models.Subuser
+		if err := db.Where("id = ? AND server_id = ?", c.Params("subuser_id"), server.ID).First(&subuser).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Subuser not found",
+			})
+		}
+
+		if err := db.Model(&subuser).Update("permissions", permissions).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to update subuser",
+			})
+		}
+		subuser.Permissions = permissions
+
+		if subuser.UserID != nil && !permissions[models.ServerPermissionConsole] {
+			hub.PublishRevocation(c.Context(), redisClient, hub.Revocation{UserID: *subuser.UserID, ServerUUID: server.UUID})
+		}
+
+		auditor.Record(c, "subuser.update", "server", &server.ID, map[string]interface{}{
+			"subuser_id":  subuser.ID,
+			"email":       subuser.Email,
+			"permissions": subuser.Permissions,
+		})
+
+		return c.JSON(subuser)
+	}
+}
+
+// revokeSubuser removes a subuser or withdraws an invite. The owner can
+// revoke anyone with server.manage; subusers can remove themselves. The
+// subuser's WebSocket connections watching the server are closed.
+func revokeSubuser(db *gorm.DB, redisClient *redis.Client, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("user_id").(float64)
+
+		var subuser models.Subuser
+		if err := db.Preload("Server").
+			Where("id = ? AND server_id = ?", c.Params("subuser_id"), c.Params("id")).
+			First(&subuser).Error; err != nil || subuser.Server == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Subuser not found",
+			})
+		}
+
+		isOwner := subuser.Server.OwnerID == uint(userID)
+		isSelf := subuser.UserID != nil && *subuser.UserID == uint(userID)
+		if !isOwner && !isSelf {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Subuser not found",
+			})
+		}
+		// Leaving a server only takes view, like the route; removing
+		// someone else is managing the server
+		if !isSelf && !middleware.Allowed(c, models.PermissionServerManage) {
+			return middleware.Forbidden(c, models.PermissionServerManage)
+		}
+
+		if err := db.Delete(&subuser).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to revoke subuser",
+			})
+		}
+
+		if subuser.UserID != nil {
+			hub.PublishRevocation(c.Context(), redisClient, hub.Revocation{UserID: *subuser.UserID, ServerUUID: subuser.Server.UUID})
+		}
+
+		auditor.Record(c, "subuser.revoke", "server", &subuser.ServerID, map[string]interface{}{
+			"subuser_id": subuser.ID,
+			"email":      subuser.Email,
+		})
+
+		return c.JSON(fiber.Map{
+			"message": "Subuser revoked",
+		})
+	}
+}
+
+// listInvites shows the caller the pending invites sent to their email.
+func listInvites(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("user_id").(float64)
+
+		var user models.User
+		if err := db.First(&user, uint(userID)).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+
+		var invites []models.Subuser
+		if err := db.Preload("Server").
+			Where("email = ? AND accepted_at IS NULL AND expires_at > ?", strings.ToLower(user.Email), time.Now()).
+			Find(&invites).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to fetch invites",
+			})
+		}
+
+		return c.JSON(invites)
+	}
+}
+
+// acceptInvite binds an invite to the caller, whose email must match the one
+// it was sent to.
+func acceptInvite(db *gorm.DB, auditor *audit.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("user_id").(float64)
+
+		var req struct {
+			Token string `json:"token"`
+		}
+
+		if err := c.BodyParser(&req); err != nil || req.Token == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "token is required",
+			})
+		}
+
+		var user models.User
+		if err := db.First(&user, uint(userID)).Error; err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+		// Invites are matched by email, so it must be proven to be theirs
+		if user.EmailVerifiedAt == nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error":                       "Verify your email before accepting invites",
+				"email_verification_required": true,
+			})
+		}
+
+		var subuser models.Subuser
+		if err := db.Where("invite_token_hash = ? AND accepted_at IS NULL AND expires_at > ?", hashInviteToken(req.Token), time.Now()).
+			First(&subuser).Error; err != nil || !strings.EqualFold(subuser.Email, user.Email) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Invite not found or expired",
+			})
+		}
+
+		now := time.Now()
+		if err := db.Model(&subuser).Updates(map[string]interface{}{
+			"user_id":           user.ID,
+			"accepted_at":       now,
+			"invite_token_hash": "",
+		}).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to accept invite",
+			})
+		}
+		db.Preload("Server").First(&subuser, subuser.ID)
+
+		auditor.Record(c, "subuser.accept", "server", &subuser.ServerID, map[string]interface{}{
+			"subuser_id": subuser.ID,
+		})
+
+		return c.JSON(subuser)
+	}
+}
@@ -0,0 +1,260 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"gaming-panel/backend/middleware"
+
+	"github.com/gofiber/websocket/v2"
+)
+
+type Client struct {
+	conn      *websocket.Conn
+	hub       *Hub
+	send      chan []byte
+	serverID  string // owned by the Run goroutine
+	room      string // the subscription as seen by readPump
+	userID    uint   // zero until the connection is authenticated
+	ip        string
+	userAgent string
+
+	// session is owned by the Run goroutine
+	session session
+}
+
+// session is what a client's access token was issued for. The connection is
+// closed when the token expires or the session is revoked.
+type session struct {
+	userID    uint
+	id        string
+	expiresAt time.Time
+}
+
+// inboundMessage is what clients send. Type is one of auth, subscribe,
+// unsubscribe or send_command.
+type inboundMessage struct {
+	Type     string `json:"type"`
+	Token    string `json:"token,omitempty"`
+	ServerID string `json:"server_id,omitempty"`
+	Command  string `json:"command,omitempty"`
+}
+
+func localString(c *websocket.Conn, key string) string {
+	value, _ := c.Locals(key).(string)
+	return value
+}
+
+// HandleConnection serves a WebSocket until it closes. Clients authenticate
+// with a token in the query string or an auth message, then subscribe to a
+// server's room.
+func (h *Hub) HandleConnection(c *websocket.Conn) {
+	client := &Client{
+		conn:      c,
+		hub:       h,
+		send:      make(chan []byte, 256),
+		ip:        localString(c, "ip"),
+		userAgent: c.Headers("User-Agent"),
+	}
+
+	if token := c.Query("token"); token != "" {
+		s, err := client.authenticate(token)
+		if err != nil {
+			c.WriteMessage(websocket.TextMessage, errorMessage(err))
+			c.Close()
+			return
+		}
+		client.session = s
+	}
+
+	h.register <- client
+
+	go client.writePump()
+
+	// Fiber closes the connection once this handler returns
+	client.readPump()
+}
+
+func (c *Client) readPump() {
+	defer func() {
+		c.hub.unregister <- c
+		c.conn.Close()
+	}()
+
+	if c.userID == 0 {
+		c.conn.SetReadDeadline(time.Now().Add(authTimeout))
+	}
+
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("WebSocket error: %v", err)
+			}
+			break
+		}
+
+		var msg inboundMessage
+		if err := json.Unmarshal(message, &msg); err != nil {
+			c.reply(errorMessage(errors.New("malformed message")))
+			continue
+		}
+
+		if msg.Type != "auth" && c.userID == 0 {
+			c.reply(errorMessage(errors.New("authentication required")))
+			continue
+		}
+
+		switch msg.Type {
+		case "auth":
+			previous := c.userID
+			s, err := c.authenticate(msg.Token)
+			if err != nil {
+				c.reply(errorMessage(err))
+				continue
+			}
+			if s.userID != previous {
+				c.room = ""
+			}
+			c.hub.login <- login{client: c, session: s}
+			c.conn.SetReadDeadline(time.Time{})
+			c.reply(typedMessage("auth_success", nil))
+
+		case "subscribe":
+			c.handleSubscribe(msg.ServerID)
+
+		case "unsubscribe":
+			c.room = ""
+			c.hub.subscribe <- subscription{client: c}
+			c.reply(typedMessage("unsubscribed", nil))
+
+		case "send_command":
+			c.handleCommand(msg.Command)
+
+		default:
+			c.reply(errorMessage(errors.New("unknown message type")))
+		}
+	}
+}
+
+// authenticate validates an access token with the same rules as the HTTP
+// auth and session middleware.
+func (c *Client) authenticate(token string) (session, error) {
+	claims, err := middleware.ParseToken(token)
+	if err != nil {
+		return session{}, err
+	}
+
+	userID, ok := claims["user_id"].(float64)
+	if !ok || userID <= 0 {
+		return session{}, errors.New("invalid token claims")
+	}
+
+	s := session{userID: uint(userID)}
+	s.id, _ = claims["sid"].(string)
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		s.expiresAt = exp.Time
+	}
+
+	if s.id != "" {
+		exists, err := c.hub.redisClient.Exists(context.Background(), middleware.SessionKey(s.id)).Result()
+		if err != nil {
+			log.Printf("Failed to check WebSocket session: %v", err)
+			return session{}, errors.New("could not check your session, try again shortly")
+		}
+		if exists == 0 {
+			return session{}, errors.New("your session has ended; log in again")
+		}
+	}
+
+	c.userID = s.userID
+	return s, nil
+}
+
+func (c *Client) handleSubscribe(serverUUID string) {
+	if serverUUID == "" {
+		c.reply(errorMessage(errors.New("server_id is required")))
+		return
+	}
+
+	if c.hub.onSubscribe == nil {
+		c.reply(errorMessage(errors.New("subscriptions are not supported")))
+		return
+	}
+
+	if err := c.hub.onSubscribe(c.userID, serverUUID); err != nil {
+		c.reply(errorMessage(err))
+		return
+	}
+
+	c.room = serverUUID
+	c.reply(typedMessage("subscribed", map[string]interface{}{"server_id": serverUUID}))
+	c.hub.subscribe <- subscription{
+		client:     c,
+		serverID:   serverUUID,
+		scrollback: c.hub.consoleScrollback(serverUUID),
+	}
+}
+
+func (c *Client) handleCommand(command string) {
+	var err error
+	switch {
+	case c.room == "":
+		err = errors.New("subscribe to a server first")
+	case c.hub.onCommand == nil:
+		err = errors.New("commands are not supported")
+	default:
+		err = c.hub.onCommand(Command{
+			UserID:     c.userID,
+			ServerUUID: c.room,
+			Command:    command,
+			IP:         c.ip,
+			UserAgent:  c.userAgent,
+		})
+	}
+
+	if err != nil {
+		c.reply(errorMessage(err))
+	}
+}
+
+// reply queues a message for this client only. It goes through Run like
+// every other send, so it can't race with the channel being closed.
+func (c *Client) reply(data []byte) {
+	c.hub.direct <- directMessage{client: c, data: data}
+}
+
+func typedMessage(messageType string, fields map[string]interface{}) []byte {
+	message := map[string]interface{}{"type": messageType}
+	for key, value := range fields {
+		message[key] = value
+	}
+	data, _ := json.Marshal(message)
+	return data
+}
+
+func errorMessage(err error) []byte {
+	return typedMessage("error", map[string]interface{}{"error": err.Error()})
+}
+
+func (c *Client) writePump() {
+	defer c.conn.Close()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				log.Printf("WebSocket write error: %v", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// ConsoleScrollback is how many console lines are kept per server and
+// replayed to clients when they subscribe.
+const ConsoleScrollback = 100
+
+// PublishConsole records a line of console output in the server's scrollback
+// and sends it to everyone watching the server.
+func (h *Hub) PublishConsole(serverUUID string, stream string, line string) {
+	message := map[string]interface{}{
+		"type":    "console",
+		"stream":  stream,
+		"message": line + "\r\n",
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling console line: %v", err)
+		return
+	}
+
+	ctx := context.Background()
+	key := consoleKey(serverUUID)
+	pipe := h.redisClient.TxPipeline()
+	pipe.RPush(ctx, key, data)
+	pipe.LTrim(ctx, key, -ConsoleScrollback, -1)
+	pipe.Expire(ctx, key, 24*time.Hour)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Error storing console scrollback: %v", err)
+	}
+
+	h.BroadcastToServer(serverUUID, message)
+}
+
+func (h *Hub) consoleScrollback(serverUUID string) [][]byte {
+	lines, err := h.redisClient.LRange(context.Background(), consoleKey(serverUUID), 0, -1).Result()
+	if err != nil {
+		log.Printf("Error loading console scrollback: %v", err)
+		return nil
+	}
+
+	scrollback := make([][]byte, len(lines))
+	for i, line := range lines {
+		scrollback[i] = []byte(line)
+	}
+	return scrollback
+}
+
+func consoleKey(serverUUID string) string {
+	return "console:" + serverUUID
+}
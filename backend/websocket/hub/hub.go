@@ -1,156 +1,275 @@
 package hub
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
+	"time"
 
 	"github.com/gofiber/websocket/v2"
+	"github.com/redis/go-redis/v9"
 )
 
+// broadcastChannel carries room messages between backend replicas, so a
+// client receives a server's events whichever replica it is connected to.
+const broadcastChannel = "ws:broadcast"
+
+// authTimeout is how long a connection may stay unauthenticated.
+const authTimeout = 10 * time.Second
+
+// expiryCheckInterval is how often connections are checked for expired
+// access tokens.
+const expiryCheckInterval = 5 * time.Second
+
+// Hub tracks this replica's WebSocket clients. Client and room state is only
+// touched by the Run goroutine; everything else talks to it over channels.
 type Hub struct {
-	clients      map[*websocket.Conn]*Client
-	serverRooms  map[string]map[*websocket.Conn]*Client
-	broadcast    chan []byte
-	register     chan *Client
-	unregister   chan *Client
+	clients     map[*websocket.Conn]*Client
+	serverRooms map[string]map[*websocket.Conn]*Client
+	register    chan *Client
+	unregister  chan *Client
+	subscribe   chan subscription
+	login       chan login
+	revoke      chan Revocation
+	deliver     chan roomMessage
+	direct      chan directMessage
+	redisClient *redis.Client
+	onSubscribe SubscribeHandler
+	onCommand   CommandHandler
+}
+
+// subscription moves a client into a server's room, or out of every room
+// when serverID is empty.
+type subscription struct {
+	client     *Client
+	serverID   string
+	scrollback [][]byte
+}
+
+// login records the session a client authenticated with.
+type login struct {
+	client  *Client
+	session session
+}
+
+// roomMessage is sent to every client in a server's room. It is also the
+// envelope published on broadcastChannel.
+type roomMessage struct {
+	ServerID string          `json:"server_id"`
+	Data     json.RawMessage `json:"data"`
 }
 
-type Client struct {
-	conn     *websocket.Conn
-	hub      *Hub
-	send     chan []byte
-	serverID string
+// directMessage is sent to a single client.
+type directMessage struct {
+	client *Client
+	data   []byte
 }
 
-func NewHub() *Hub {
+// SubscribeHandler reports whether a user may watch a server's events.
+type SubscribeHandler func(userID uint, serverUUID string) error
+
+// Command is a console command a client sent over its WebSocket.
+type Command struct {
+	UserID     uint
+	ServerUUID string
+	Command    string
+	IP         string
+	UserAgent  string
+}
+
+// CommandHandler checks that the sender may run a command on the server and
+// forwards it to the daemon.
+type CommandHandler func(cmd Command) error
+
+func NewHub(redisClient *redis.Client) *Hub {
 	return &Hub{
+		redisClient: redisClient,
 		clients:     make(map[*websocket.Conn]*Client),
 		serverRooms: make(map[string]map[*websocket.Conn]*Client),
-		broadcast:   make(chan []byte),
 		register:    make(chan *Client),
 		unregister:  make(chan *Client),
+		subscribe:   make(chan subscription),
+		login:       make(chan login),
+		revoke:      make(chan Revocation, 16),
+		deliver:     make(chan roomMessage, 256),
+		direct:      make(chan directMessage),
 	}
 }
 
 func (h *Hub) Run() {
+	go h.listen(context.Background())
+
+	ticker := time.NewTicker(expiryCheckInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case client := <-h.register:
 			h.clients[client.conn] = client
-			if client.serverID != "" {
-				if h.serverRooms[client.serverID] == nil {
-					h.serverRooms[client.serverID] = make(map[*websocket.Conn]*Client)
-				}
-				h.serverRooms[client.serverID][client.conn] = client
-			}
 			log.Printf("Client connected. Total clients: %d", len(h.clients))
 
 		case client := <-h.unregister:
-			if _, ok := h.clients[client.conn]; ok {
-				delete(h.clients, client.conn)
-				close(client.send)
-				
-				if client.serverID != "" && h.serverRooms[client.serverID] != nil {
-					delete(h.serverRooms[client.serverID], client.conn)
+			h.removeClient(client)
+			log.Printf("Client disconnected. Total clients: %d", len(h.clients))
+
+		case sub := <-h.subscribe:
+			client := sub.client
+			if _, ok := h.clients[client.conn]; !ok {
+				continue
+			}
+
+			h.leaveRoom(client)
+			if sub.serverID == "" {
+				continue
+			}
+
+			client.serverID = sub.serverID
+			if h.serverRooms[sub.serverID] == nil {
+				h.serverRooms[sub.serverID] = make(map[*websocket.Conn]*Client)
+			}
+			h.serverRooms[sub.serverID][client.conn] = client
+
+			// Replay recent console output so the console isn't blank
+			for _, line := range sub.scrollback {
+				h.sendTo(client, line)
+			}
+
+		case l := <-h.login:
+			client := l.client
+			if _, ok := h.clients[client.conn]; !ok {
+				continue
+			}
+			// A room was only allowed for the user who subscribed
+			if client.session.userID != l.session.userID {
+				h.leaveRoom(client)
+			}
+			client.session = l.session
+
+		case r := <-h.revoke:
+			for _, client := range h.clients {
+				if r.matches(client.session, client.serverID) {
+					h.disconnect(client, errors.New(r.reason()))
 				}
 			}
-			log.Printf("Client disconnected. Total clients: %d", len(h.clients))
 
-		case message := <-h.broadcast:
-			for clientConn := range h.clients {
-				client := h.clients[clientConn]
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, clientConn)
+		case now := <-ticker.C:
+			for _, client := range h.clients {
+				if expiresAt := client.session.expiresAt; !expiresAt.IsZero() && now.After(expiresAt) {
+					h.disconnect(client, errors.New("your access token expired; reconnect with a new one"))
 				}
 			}
+
+		case msg := <-h.deliver:
+			for _, client := range h.serverRooms[msg.ServerID] {
+				h.sendTo(client, msg.Data)
+			}
+
+		case msg := <-h.direct:
+			if _, ok := h.clients[msg.client.conn]; ok {
+				h.sendTo(msg.client, msg.data)
+			}
 		}
 	}
 }
 
-func (h *Hub) HandleConnection(c *websocket.Conn) {
-	client := &Client{
-		conn: c,
-		hub:  h,
-		send: make(chan []byte, 256),
+// sendTo queues data for a client. A client that can't keep up is dropped
+// rather than allowed to stall everyone else.
+func (h *Hub) sendTo(client *Client, data []byte) {
+	select {
+	case client.send <- data:
+	default:
+		log.Printf("Dropping slow WebSocket client")
+		h.removeClient(client)
 	}
+}
 
-	h.register <- client
-
-	go client.writePump()
-	go client.readPump()
+// disconnect tells a client why and closes its connection once the reason
+// has been written.
+func (h *Hub) disconnect(client *Client, reason error) {
+	h.sendTo(client, errorMessage(reason))
+	h.removeClient(client)
 }
 
-func (h *Hub) BroadcastToServer(serverUUID string, message interface{}) {
-	data, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Error marshaling message: %v", err)
+// removeClient forgets a client and closes its send channel, which makes
+// writePump close the connection. It is safe to call more than once.
+func (h *Hub) removeClient(client *Client) {
+	if _, ok := h.clients[client.conn]; !ok {
 		return
 	}
+	h.leaveRoom(client)
+	delete(h.clients, client.conn)
+	close(client.send)
+}
 
-	if room, ok := h.serverRooms[serverUUID]; ok {
-		for clientConn := range room {
-			client := room[clientConn]
-			select {
-			case client.send <- data:
-			default:
-				close(client.send)
-				delete(h.clients, clientConn)
-				if h.serverRooms[serverUUID] != nil {
-					delete(h.serverRooms[serverUUID], clientConn)
-				}
-			}
+func (h *Hub) leaveRoom(client *Client) {
+	if client.serverID == "" {
+		return
+	}
+	if room := h.serverRooms[client.serverID]; room != nil {
+		delete(room, client.conn)
+		if len(room) == 0 {
+			delete(h.serverRooms, client.serverID)
 		}
 	}
+	client.serverID = ""
 }
 
-func (c *Client) readPump() {
-	defer func() {
-		c.hub.unregister <- c
-		c.conn.Close()
-	}()
+// OnSubscribe sets the check for subscribe messages. It must be called
+// before clients connect.
+func (h *Hub) OnSubscribe(handler SubscribeHandler) {
+	h.onSubscribe = handler
+}
 
-	for {
-		_, message, err := c.conn.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
-			}
-			break
-		}
+// OnCommand sets the handler for send_command messages. It must be called
+// before clients connect.
+func (h *Hub) OnCommand(handler CommandHandler) {
+	h.onCommand = handler
+}
 
-		// Parse message to get server UUID
-		var msg map[string]interface{}
-		if err := json.Unmarshal(message, &msg); err == nil {
-			if serverID, ok := msg["server_id"].(string); ok {
-				c.serverID = serverID
-				// Re-register with server room
-				c.hub.unregister <- c
-				c.hub.register <- c
-			}
-		}
+// BroadcastToServer sends a message to every client watching the server on
+// any backend replica. It is safe to call from any goroutine.
+func (h *Hub) BroadcastToServer(serverUUID string, message interface{}) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling message: %v", err)
+		return
+	}
 
-		c.hub.broadcast <- message
+	msg := roomMessage{ServerID: serverUUID, Data: data}
+	envelope, err := json.Marshal(msg)
+	if err == nil {
+		err = h.redisClient.Publish(context.Background(), broadcastChannel, envelope).Err()
+	}
+	if err != nil {
+		// Still reach the clients connected to this replica
+		log.Printf("Error publishing broadcast, delivering locally: %v", err)
+		h.deliver <- msg
 	}
 }
 
-func (c *Client) writePump() {
-	defer c.conn.Close()
+// listen delivers broadcasts and revocations published by any replica,
+// including this one, to the local clients.
+func (h *Hub) listen(ctx context.Context) {
+	pubsub := h.redisClient.Subscribe(ctx, broadcastChannel, revokeChannel)
+	defer pubsub.Close()
 
-	for {
-		select {
-		case message, ok := <-c.send:
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
+	for msg := range pubsub.Channel() {
+		if msg.Channel == revokeChannel {
+			var r Revocation
+			if err := json.Unmarshal([]byte(msg.Payload), &r); err != nil {
+				log.Printf("Invalid WebSocket revocation: %v", err)
+				continue
 			}
+			h.revoke <- r
+			continue
+		}
 
-			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
-				log.Printf("WebSocket write error: %v", err)
-				return
-			}
+		var room roomMessage
+		if err := json.Unmarshal([]byte(msg.Payload), &room); err != nil || room.ServerID == "" {
+			log.Printf("Invalid broadcast envelope: %v", err)
+			continue
 		}
+		h.deliver <- room
 	}
 }
@@ -0,0 +1,50 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// revokeChannel carries revocations between backend replicas, so a
+// connection is closed whichever replica it is on.
+const revokeChannel = "ws:revoke"
+
+// Revocation closes the connections it matches: every connection of a
+// session, or of a user, or a user's connections watching one server.
+type Revocation struct {
+	SessionID  string `json:"session_id,omitempty"`
+	UserID     uint   `json:"user_id,omitempty"`
+	ServerUUID string `json:"server_uuid,omitempty"`
+}
+
+func (r Revocation) matches(s session, serverID string) bool {
+	if r.SessionID != "" {
+		return s.id == r.SessionID
+	}
+	if r.UserID == 0 || s.userID != r.UserID {
+		return false
+	}
+	return r.ServerUUID == "" || serverID == r.ServerUUID
+}
+
+func (r Revocation) reason() string {
+	if r.ServerUUID != "" {
+		return "your access to this server was revoked"
+	}
+	return "your session has ended; log in again"
+}
+
+// PublishRevocation closes the matching connections on every replica. It
+// only needs Redis, so it can be called without a Hub.
+func PublishRevocation(ctx context.Context, redisClient *redis.Client, r Revocation) {
+	data, err := json.Marshal(r)
+	if err == nil {
+		err = redisClient.Publish(ctx, revokeChannel, data).Err()
+	}
+	if err != nil {
+		log.Printf("Error publishing WebSocket revocation: %v", err)
+	}
+}
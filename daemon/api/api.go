@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gaming-panel/daemon/backup"
+	"gaming-panel/daemon/config"
+)
+
+// Server exposes the node's HTTP endpoints. Requests are authorized by
+// short-lived links the backend signs with the node's signing key.
+// Links travel in the clear unless TLS_CERT_FILE and TLS_KEY_FILE are set.
+type Server struct {
+	cfg     *config.Config
+	storage backup.Storage
+	http    *http.Server
+}
+
+func NewServer(cfg *config.Config, storage backup.Storage) *Server {
+	s := &Server{cfg: cfg, storage: storage}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/backups/", s.downloadBackup)
+
+	s.http = &http.Server{
+		Addr:              cfg.ListenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+func (s *Server) Start(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.http.Shutdown(shutdownCtx)
+	}()
+
+	var err error
+	if s.cfg.TLSCertFile != "" && s.cfg.TLSKeyFile != "" {
+		err = s.http.ListenAndServeTLS(s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
+	} else {
+		log.Printf("TLS_CERT_FILE and TLS_KEY_FILE are not set, serving download links over plain HTTP")
+		err = s.http.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Printf("HTTP server error: %v", err)
+	}
+}
+
+// downloadBackup serves GET /backups/<server uuid>/<backup id>?expires=&signature=
+func (s *Server) downloadBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/backups/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	serverUUID := parts[0]
+	backupID, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		http.Error(w, "link expired", http.StatusForbidden)
+		return
+	}
+
+	expected := signBackupDownload(s.cfg.SigningKey(), serverUUID, uint(backupID), expires)
+	if !hmac.Equal([]byte(expected), []byte(r.URL.Query().Get("signature"))) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+
+	archive, size, err := s.storage.Open(r.Context(), backup.Key(serverUUID, uint(backupID)))
+	if err != nil {
+		log.Printf("Failed to open backup %d for download: %v", backupID, err)
+		http.NotFound(w, r)
+		return
+	}
+	defer archive.Close()
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="backup-%s-%d.tar.gz"`, serverUUID, backupID))
+
+	// Local archives support range requests so interrupted downloads resume
+	if file, ok := archive.(*os.File); ok {
+		info, err := file.Stat()
+		if err != nil {
+			http.Error(w, "failed to read backup", http.StatusInternalServerError)
+			return
+		}
+		http.ServeContent(w, r, "", info.ModTime(), file)
+		return
+	}
+
+	if size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, archive); err != nil {
+		log.Printf("Backup %d download interrupted: %v", backupID, err)
+	}
+}
+
+// signBackupDownload must stay in sync with the backend, which issues the links.
+func signBackupDownload(key []byte, serverUUID string, backupID uint, expires int64) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s:%d:%d", serverUUID, backupID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
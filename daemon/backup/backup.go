@@ -0,0 +1,138 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Archive describes a finished backup archive on local disk.
+type Archive struct {
+	Path     string
+	Size     int64
+	Checksum string // hex-encoded SHA-256 of the archive
+}
+
+// Key identifies a backup archive within a storage backend.
+func Key(serverUUID string, backupID uint) string {
+	return fmt.Sprintf("%s/%d.tar.gz", serverUUID, backupID)
+}
+
+// LocalPath is where a server's backup archive is written on the node before
+// it is handed to the storage backend.
+func LocalPath(backupDir, serverUUID string, backupID uint) string {
+	return filepath.Join(backupDir, filepath.FromSlash(Key(serverUUID, backupID)))
+}
+
+// Create writes a gzip-compressed tarball of srcDir to destPath. Paths inside
+// the archive are relative to srcDir. A partially written archive is removed
+// on failure.
+func Create(srcDir, destPath string) (*Archive, error) {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	file, err := os.Create(destPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive: %w", err)
+	}
+
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(file, hash)}
+
+	if err := writeTarGz(srcDir, counter); err != nil {
+		file.Close()
+		os.Remove(destPath)
+		return nil, err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(destPath)
+		return nil, fmt.Errorf("failed to close archive: %w", err)
+	}
+
+	return &Archive{
+		Path:     destPath,
+		Size:     counter.n,
+		Checksum: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+func writeTarGz(srcDir string, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	err := filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %w", srcDir, err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finalize tar stream: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to finalize gzip stream: %w", err)
+	}
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,58 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// LocalStorage keeps archives on the node's own disk under a root directory.
+type LocalStorage struct {
+	root string
+}
+
+func NewLocalStorage(root string) *LocalStorage {
+	return &LocalStorage{root: root}
+}
+
+func (s *LocalStorage) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+func (s *LocalStorage) Store(ctx context.Context, key, localPath string) (string, error) {
+	target := s.path(key)
+	if target == filepath.Clean(localPath) {
+		return target, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	if err := os.Rename(localPath, target); err != nil {
+		return "", fmt.Errorf("failed to move archive into place: %w", err)
+	}
+	return target, nil
+}
+
+func (s *LocalStorage) Open(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	file, err := os.Open(s.path(key))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open archive: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("failed to stat archive: %w", err)
+	}
+	return file, info.Size(), nil
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete archive: %w", err)
+	}
+	return nil
+}
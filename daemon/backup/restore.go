@@ -0,0 +1,169 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Extract unpacks a gzip-compressed tarball created by Create into destDir.
+// Entries that would land outside destDir are rejected. destDir may hold
+// files the server wrote, so symlinks already in it are never followed: one
+// standing where the archive has a directory or file is replaced.
+func Extract(r io.Reader, destDir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to read gzip stream: %w", err)
+	}
+	defer gz.Close()
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", destDir, err)
+	}
+
+	root := filepath.Clean(destDir)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar stream: %w", err)
+		}
+
+		target := filepath.Join(root, filepath.FromSlash(header.Name))
+		if !within(root, target) {
+			return fmt.Errorf("archive entry %q escapes the destination", header.Name)
+		}
+
+		mode := os.FileMode(header.Mode).Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := mkdirNoFollow(root, target, mode|0700); err != nil {
+				return err
+			}
+
+		case tar.TypeReg:
+			if err := mkdirNoFollow(root, filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := removeSymlink(target); err != nil {
+				return err
+			}
+			if err := writeFile(target, tr, mode); err != nil {
+				return err
+			}
+
+		case tar.TypeSymlink:
+			linkTarget := header.Linkname
+			if !filepath.IsAbs(linkTarget) {
+				linkTarget = filepath.Join(filepath.Dir(target), linkTarget)
+			}
+			if !within(root, linkTarget) {
+				return fmt.Errorf("symlink %q points outside the destination", header.Name)
+			}
+			if err := mkdirNoFollow(root, filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			os.Remove(target)
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+
+		default:
+			// Devices, FIFOs and hard links never appear in server data
+		}
+	}
+}
+
+// mkdirNoFollow creates dir and its parents below root like MkdirAll, but
+// replaces symlinks and files in the way instead of following them.
+func mkdirNoFollow(root, dir string, mode os.FileMode) error {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." {
+		return err
+	}
+
+	current := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if err == nil && info.IsDir() {
+			continue
+		}
+		if err == nil {
+			// A symlink or file where the archive has a directory
+			if err := os.Remove(current); err != nil {
+				return err
+			}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err := os.Mkdir(current, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeSymlink removes path if it is a symlink, so it is replaced rather
+// than written through.
+func removeSymlink(path string) error {
+	info, err := os.Lstat(path)
+	if err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return os.Remove(path)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// writeFile refuses to open path through a symlink, in case one was planted
+// after removeSymlink ran.
+func writeFile(path string, r io.Reader, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|syscall.O_NOFOLLOW, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, filepath.Clean(path))
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Wipe removes everything inside dir while keeping dir itself, so bind
+// mounts pointing at it stay valid.
+func Wipe(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
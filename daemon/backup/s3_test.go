@@ -0,0 +1,149 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// These tests run against a real S3-compatible store. Start the MinIO
+// service with `docker compose --profile s3 up -d minio minio-setup` and run
+//
+//	S3_TEST_ENDPOINT=http://localhost:9000 go test ./backup/
+//
+// S3_TEST_BUCKET, S3_TEST_ACCESS_KEY and S3_TEST_SECRET_KEY default to the
+// compose service's values.
+func newTestS3Storage(t *testing.T) *S3Storage {
+	t.Helper()
+
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT is not set")
+	}
+
+	storage, err := NewS3Storage(S3Config{
+		Endpoint:  endpoint,
+		Bucket:    testEnv("S3_TEST_BUCKET", "backups"),
+		AccessKey: testEnv("S3_TEST_ACCESS_KEY", "minioadmin"),
+		SecretKey: testEnv("S3_TEST_SECRET_KEY", "minioadmin"),
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatalf("NewS3Storage: %v", err)
+	}
+	return storage
+}
+
+func testEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func testKey() string {
+	return fmt.Sprintf("test/%d.tar.gz", time.Now().UnixNano())
+}
+
+func TestS3StoreAndOpen(t *testing.T) {
+	storage := newTestS3Storage(t)
+	ctx := context.Background()
+
+	// Just over one part, so the upload has a full part and a short one
+	data := make([]byte, s3PartSize+1024)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	localPath := filepath.Join(t.TempDir(), "archive.tar.gz")
+	if err := os.WriteFile(localPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	key := testKey()
+	location, err := storage.Store(ctx, key, localPath)
+	if err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	defer storage.Delete(ctx, key)
+
+	if want := "s3://" + storage.cfg.Bucket + "/" + key; location != want {
+		t.Errorf("location = %q, want %q", location, want)
+	}
+	if _, err := os.Stat(localPath); !os.IsNotExist(err) {
+		t.Errorf("local archive was not removed after the upload: %v", err)
+	}
+
+	archive, size, err := storage.Open(ctx, key)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	got, err := io.ReadAll(archive)
+	archive.Close()
+	if err != nil {
+		t.Fatalf("reading archive: %v", err)
+	}
+	if size != int64(len(data)) {
+		t.Errorf("size = %d, want %d", size, len(data))
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("downloaded archive differs from the uploaded one")
+	}
+
+	if err := storage.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, _, err := storage.Open(ctx, key); err == nil {
+		t.Error("Open succeeded after Delete")
+	}
+	// Deleting what is already gone is not an error
+	if err := storage.Delete(ctx, key); err != nil {
+		t.Errorf("second Delete: %v", err)
+	}
+}
+
+func TestS3AbortMultipartUpload(t *testing.T) {
+	storage := newTestS3Storage(t)
+	ctx := context.Background()
+	key := testKey()
+
+	uploadID, err := storage.createMultipartUpload(ctx, key)
+	if err != nil {
+		t.Fatalf("createMultipartUpload: %v", err)
+	}
+	if _, err := storage.uploadParts(ctx, key, uploadID, bytes.NewReader([]byte("partial"))); err != nil {
+		t.Fatalf("uploadParts: %v", err)
+	}
+
+	storage.abortMultipartUpload(ctx, key, uploadID)
+
+	// Listing the parts of an aborted upload fails with NoSuchUpload
+	resp, err := storage.do(ctx, http.MethodGet, key, url.Values{"uploadId": {uploadID}}, nil, nil)
+	if err != nil {
+		t.Fatalf("list parts: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("list parts after abort: status %s, want 404", resp.Status)
+	}
+
+	if _, _, err := storage.Open(ctx, key); err == nil {
+		t.Error("an aborted upload left an object behind")
+	}
+}
+
+func TestS3StoreMissingArchive(t *testing.T) {
+	storage := newTestS3Storage(t)
+
+	_, err := storage.Store(context.Background(), testKey(), filepath.Join(t.TempDir(), "missing.tar.gz"))
+	if err == nil {
+		t.Fatal("Store succeeded without an archive")
+	}
+}
@@ -0,0 +1,43 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"gaming-panel/daemon/config"
+)
+
+// Storage is where finished backup archives are kept. Archives are always
+// written to local disk first and then handed to the storage backend.
+type Storage interface {
+	// Store moves the archive at localPath into storage under key and
+	// returns a location describing where it ended up.
+	Store(ctx context.Context, key, localPath string) (string, error)
+
+	// Open returns the archive stored under key along with its size.
+	Open(ctx context.Context, key string) (io.ReadCloser, int64, error)
+
+	// Delete removes the archive stored under key. Deleting a missing
+	// archive is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// NewStorage returns the storage backend selected in the node configuration.
+func NewStorage(cfg *config.Config) (Storage, error) {
+	switch cfg.BackupStorage {
+	case "", "local":
+		return NewLocalStorage(cfg.BackupDir), nil
+	case "s3":
+		return NewS3Storage(S3Config{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			PathStyle: cfg.S3PathStyle,
+		})
+	default:
+		return nil, fmt.Errorf("unknown backup storage %q", cfg.BackupStorage)
+	}
+}
@@ -1,23 +1,71 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"os"
 )
 
 type Config struct {
-	NodeID    string
-	RedisURL  string
-	DockerHost string
+	// The node's identity, from CredentialsFile once the daemon has enrolled
+	// with the panel using EnrollmentToken
+	NodeID          uint
+	NodeToken       string
+	APIURL          string // the panel's API, e.g. http://panel:3000/api/v1
+	EnrollmentToken string
+	CredentialsFile string
+
+	RedisURL    string
+	DockerHost  string
+	DataDir     string
+	BackupDir   string
+	ListenAddr  string
+	TLSCertFile string // the HTTP server uses TLS when both are set
+	TLSKeyFile  string
+
+	// Backup storage: "local" keeps archives in BackupDir, "s3" uploads them
+	// to an S3-compatible bucket
+	BackupStorage string
+	S3Endpoint    string
+	S3Region      string
+	S3Bucket      string
+	S3AccessKey   string
+	S3SecretKey   string
+	S3PathStyle   bool
 }
 
 func Load() *Config {
 	return &Config{
-		NodeID:     getEnv("NODE_ID", "node-1"),
-		RedisURL:   getEnv("REDIS_URL", "redis://localhost:6379/0"),
-		DockerHost: getEnv("DOCKER_HOST", "unix:///var/run/docker.sock"),
+		APIURL:          getEnv("API_URL", "http://localhost:3000/api/v1"),
+		EnrollmentToken: getEnv("ENROLLMENT_TOKEN", ""),
+		CredentialsFile: getEnv("CREDENTIALS_FILE", "/var/lib/gaming-panel/node.json"),
+
+		RedisURL:    getEnv("REDIS_URL", "redis://localhost:6379/0"),
+		DockerHost:  getEnv("DOCKER_HOST", "unix:///var/run/docker.sock"),
+		DataDir:     getEnv("DATA_DIR", "/var/lib/gaming-panel/volumes"),
+		BackupDir:   getEnv("BACKUP_DIR", "/var/lib/gaming-panel/backups"),
+		ListenAddr:  getEnv("LISTEN_ADDR", ":8080"),
+		TLSCertFile: getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:  getEnv("TLS_KEY_FILE", ""),
+
+		BackupStorage: getEnv("BACKUP_STORAGE", "local"),
+		S3Endpoint:    getEnv("S3_ENDPOINT", ""),
+		S3Region:      getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:      getEnv("S3_BUCKET", ""),
+		S3AccessKey:   getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:   getEnv("S3_SECRET_KEY", ""),
+		S3PathStyle:   getEnv("S3_PATH_STYLE", "false") == "true",
 	}
 }
 
+// SigningKey is what the node and the panel sign messages to each other
+// with. The panel keeps only the SHA-256 of the node token, so both sides
+// use that rather than the token itself.
+func (c *Config) SigningKey() []byte {
+	sum := sha256.Sum256([]byte(c.NodeToken))
+	return []byte(hex.EncodeToString(sum[:]))
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
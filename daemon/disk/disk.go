@@ -0,0 +1,37 @@
+// Package disk measures how much space server data takes up.
+package disk
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+)
+
+// Usage adds up the size of the files under path. Symlinks are counted
+// themselves, not followed, so a server can't make its usage look smaller
+// or larger by pointing outside its directory.
+func Usage(path string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Files can disappear while a running server is walked
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
@@ -48,6 +48,18 @@ func (c *Client) CreateContainer(ctx context.Context, config *container.Config,
 	return resp.ID, nil
 }
 
+func (c *Client) PullImage(ctx context.Context, image string) error {
+	reader, err := c.cli.ImagePull(ctx, image, types.ImagePullOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", image, err)
+	}
+	defer reader.Close()
+
+	// The pull only completes once the progress stream has been drained
+	_, err = io.Copy(io.Discard, reader)
+	return err
+}
+
 func (c *Client) RemoveContainer(ctx context.Context, containerID string, force bool) error {
 	return c.cli.ContainerRemove(ctx, containerID, types.ContainerRemoveOptions{Force: force})
 }
@@ -60,17 +72,51 @@ func (c *Client) GetContainerLogs(ctx context.Context, containerID string, optio
 	return c.cli.ContainerLogs(ctx, containerID, options)
 }
 
+// WriteStdin attaches to a container's stdin and writes data to it. The
+// container must have been created with OpenStdin.
+func (c *Client) WriteStdin(ctx context.Context, containerID string, data []byte) error {
+	resp, err := c.cli.ContainerAttach(ctx, containerID, types.ContainerAttachOptions{
+		Stream: true,
+		Stdin:  true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to attach to container: %w", err)
+	}
+	defer resp.Close()
+
+	if _, err := resp.Conn.Write(data); err != nil {
+		return fmt.Errorf("failed to write to stdin: %w", err)
+	}
+	return nil
+}
+
+// ListContainers lists containers, including stopped ones, matching every
+// label in labelFilter. An empty value matches any container with the label.
 func (c *Client) ListContainers(ctx context.Context, labelFilter map[string]string) ([]types.Container, error) {
 	filterArgs := filters.NewArgs()
 	for key, value := range labelFilter {
+		if value == "" {
+			filterArgs.Add("label", key)
+			continue
+		}
 		filterArgs.Add("label", fmt.Sprintf("%s=%s", key, value))
 	}
 
 	return c.cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
 		Filters: filterArgs,
 	})
 }
 
+// ServerVersion returns the version of the Docker Engine.
+func (c *Client) ServerVersion(ctx context.Context) (string, error) {
+	version, err := c.cli.ServerVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+	return version.Version, nil
+}
+
 func (c *Client) InspectContainer(ctx context.Context, containerID string) (types.ContainerJSON, error) {
 	return c.cli.ContainerInspect(ctx, containerID)
 }
@@ -0,0 +1,227 @@
+// Package heartbeat tells the panel the node is alive and what resources it
+// has and uses. The backend marks nodes offline when heartbeats stop.
+package heartbeat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"gaming-panel/daemon/config"
+	"gaming-panel/daemon/disk"
+	"gaming-panel/daemon/docker"
+	"gaming-panel/daemon/panel"
+
+	"github.com/docker/docker/api/types"
+	"github.com/redis/go-redis/v9"
+)
+
+// Interval is how often a heartbeat is sent. The backend allows a few to go
+// missing before it marks the node offline.
+const Interval = 15 * time.Second
+
+// diskInterval is how often DATA_DIR is measured. Walking every server's
+// files is slow, so heartbeats in between repeat the last figure.
+const diskInterval = time.Minute
+
+// heartbeatChannel is where heartbeats are published, signed with the
+// node's key.
+const heartbeatChannel = "node:heartbeat"
+
+// Heartbeat is published on heartbeatChannel. Figures the daemon couldn't
+// read are left out, so the panel keeps the last known ones.
+type Heartbeat struct {
+	NodeID        uint   `json:"node_id"`
+	DaemonVersion string `json:"daemon_version"`
+	DockerVersion string `json:"docker_version,omitempty"`
+	TotalRAM      *int64 `json:"total_ram,omitempty"`  // bytes
+	TotalCPU      int64  `json:"total_cpu"`            // nano CPUs
+	TotalDisk     *int64 `json:"total_disk,omitempty"` // bytes on DATA_DIR's filesystem
+	UsedRAM       *int64 `json:"used_ram,omitempty"`   // bytes, by server containers
+	UsedCPU       *int64 `json:"used_cpu,omitempty"`   // nano CPUs, by server containers
+	UsedDisk      *int64 `json:"used_disk,omitempty"`  // bytes of server data under DATA_DIR
+}
+
+type Reporter struct {
+	cfg          *config.Config
+	redisClient  *redis.Client
+	dockerClient *docker.Client
+	version      string
+
+	// The last measurement of DATA_DIR, owned by Start's goroutine
+	usedDisk   *int64
+	measuredAt time.Time
+}
+
+func NewReporter(cfg *config.Config, dockerClient *docker.Client, version string) *Reporter {
+	opt, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		log.Fatalf("Failed to parse Redis URL: %v", err)
+	}
+
+	return &Reporter{
+		cfg:          cfg,
+		redisClient:  redis.NewClient(opt),
+		dockerClient: dockerClient,
+		version:      version,
+	}
+}
+
+// Start sends a heartbeat straight away and then every Interval until ctx is
+// cancelled.
+func (r *Reporter) Start(ctx context.Context) {
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+	defer r.redisClient.Close()
+
+	for {
+		r.send(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Reporter) send(ctx context.Context) {
+	data, _ := json.Marshal(r.collect(ctx))
+	message, err := panel.SignMessage(r.cfg.SigningKey(), heartbeatChannel, string(data))
+	if err == nil {
+		err = r.redisClient.Publish(ctx, heartbeatChannel, message).Err()
+	}
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Failed to send heartbeat: %v", err)
+	}
+}
+
+// collect gathers what it can. A figure that can't be read is left out
+// rather than holding up the heartbeat.
+func (r *Reporter) collect(ctx context.Context) Heartbeat {
+	beat := Heartbeat{
+		NodeID:        r.cfg.NodeID,
+		DaemonVersion: r.version,
+		TotalCPU:      cpuCount() * 1e9,
+	}
+
+	var err error
+	if beat.DockerVersion, err = r.dockerClient.ServerVersion(ctx); err != nil {
+		log.Printf("Heartbeat: failed to read Docker version: %v", err)
+	}
+	if total, err := memoryTotal(); err == nil {
+		beat.TotalRAM = &total
+	} else {
+		log.Printf("Heartbeat: failed to read memory: %v", err)
+	}
+	if total, err := diskTotal(r.cfg.DataDir); err == nil {
+		beat.TotalDisk = &total
+	} else {
+		log.Printf("Heartbeat: failed to read the size of %s's filesystem: %v", r.cfg.DataDir, err)
+	}
+	if time.Since(r.measuredAt) >= diskInterval {
+		r.measuredAt = time.Now()
+		if used, err := disk.Usage(r.cfg.DataDir); err == nil {
+			r.usedDisk = &used
+		} else {
+			r.usedDisk = nil
+			log.Printf("Heartbeat: failed to measure %s: %v", r.cfg.DataDir, err)
+		}
+	}
+	beat.UsedDisk = r.usedDisk
+	if memory, cpu, err := r.containerUsage(ctx); err == nil {
+		beat.UsedRAM, beat.UsedCPU = &memory, &cpu
+	} else {
+		log.Printf("Heartbeat: %v", err)
+	}
+
+	return beat
+}
+
+// containerUsage adds up the memory and CPU used by running servers. It
+// fails if any of them can't be read, rather than report too little.
+func (r *Reporter) containerUsage(ctx context.Context) (memory, cpu int64, err error) {
+	containers, err := r.dockerClient.ListContainers(ctx, map[string]string{
+		"server.id": "",
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	// Docker takes a second to sample each container's CPU use, so they
+	// are sampled together
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var failed error
+	for _, c := range containers {
+		if c.State != "running" {
+			continue
+		}
+
+		wg.Add(1)
+		go func(containerID string) {
+			defer wg.Done()
+
+			stats, err := r.containerStats(ctx, containerID)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed = fmt.Errorf("failed to read stats of container %s: %w", containerID, err)
+				return
+			}
+			memory += memoryUsed(stats.MemoryStats)
+			cpu += cpuUsed(stats.CPUStats, stats.PreCPUStats)
+		}(c.ID)
+	}
+	wg.Wait()
+
+	if failed != nil {
+		return 0, 0, failed
+	}
+	return memory, cpu, nil
+}
+
+func (r *Reporter) containerStats(ctx context.Context, containerID string) (types.StatsJSON, error) {
+	var stats types.StatsJSON
+	resp, err := r.dockerClient.GetContainerStats(ctx, containerID, false)
+	if err != nil {
+		return stats, err
+	}
+	defer resp.Body.Close()
+
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	return stats, err
+}
+
+// memoryUsed leaves out the page cache the kernel can reclaim, as docker
+// stats does. cgroup v2 calls it inactive_file, v1 total_inactive_file.
+func memoryUsed(stats types.MemoryStats) int64 {
+	used := stats.Usage
+	for _, key := range []string{"inactive_file", "total_inactive_file"} {
+		if inactive, ok := stats.Stats[key]; ok && inactive < used {
+			used -= inactive
+			break
+		}
+	}
+	return int64(used)
+}
+
+// cpuUsed is the container's share of the host's CPU time between the two
+// samples, in nano CPUs.
+func cpuUsed(stats, previous types.CPUStats) int64 {
+	if stats.CPUUsage.TotalUsage < previous.CPUUsage.TotalUsage || stats.SystemUsage <= previous.SystemUsage {
+		return 0
+	}
+
+	cpus := float64(stats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(stats.CPUUsage.PercpuUsage))
+	}
+	container := float64(stats.CPUUsage.TotalUsage - previous.CPUUsage.TotalUsage)
+	system := float64(stats.SystemUsage - previous.SystemUsage)
+	return int64(container / system * cpus * 1e9)
+}
@@ -0,0 +1,61 @@
+package heartbeat
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// memoryTotal reads the host's memory from /proc/meminfo, which containers
+// share with the host.
+func memoryTotal() (int64, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// MemTotal:       16318748 kB
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			return kb * 1024, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errors.New("no MemTotal in /proc/meminfo")
+}
+
+// cpuCount counts the host's CPUs in /proc/cpuinfo, falling back to the ones
+// the daemon may run on.
+func cpuCount() int64 {
+	count := 0
+	if data, err := os.ReadFile("/proc/cpuinfo"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.HasPrefix(line, "processor") {
+				count++
+			}
+		}
+	}
+	if count == 0 {
+		count = runtime.NumCPU()
+	}
+	return int64(count)
+}
+
+// diskTotal reports the size of the filesystem holding path.
+func diskTotal(path string) (int64, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return 0, err
+	}
+	return int64(fs.Blocks) * int64(fs.Bsize), nil
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package heartbeat
+
+import (
+	"errors"
+	"runtime"
+)
+
+// Nodes run on Linux; elsewhere the host figures are left out so the daemon
+// still builds for development.
+
+var errUnsupported = errors.New("not supported on " + runtime.GOOS)
+
+func memoryTotal() (int64, error) {
+	return 0, errUnsupported
+}
+
+func cpuCount() int64 {
+	return int64(runtime.NumCPU())
+}
+
+func diskTotal(path string) (int64, error) {
+	return 0, errUnsupported
+}
@@ -0,0 +1,144 @@
+package listener
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"gaming-panel/daemon/backup"
+)
+
+func (rl *RedisListener) handleBackup(ctx context.Context, server ServerConfig) {
+	log.Printf("Creating backup %d for server %d", server.BackupID, server.ServerID)
+
+	result := map[string]interface{}{
+		"backup_id": server.BackupID,
+		"server_id": server.ServerID,
+		"success":   false,
+	}
+
+	if server.BackupID == 0 || server.UUID == "" {
+		result["error"] = "backup request is missing backup_id or uuid"
+		rl.publishBackupStatus(ctx, result)
+		return
+	}
+
+	// The archive is taken live; game servers flush their world saves
+	// periodically, so a running server yields a consistent enough copy.
+	archive, err := backup.Create(rl.dataDir(server.UUID), backup.LocalPath(rl.cfg.BackupDir, server.UUID, server.BackupID))
+	if err != nil {
+		log.Printf("Backup %d for server %d failed: %v", server.BackupID, server.ServerID, err)
+		result["error"] = err.Error()
+		rl.publishBackupStatus(ctx, result)
+		return
+	}
+
+	location, err := rl.storage.Store(ctx, backup.Key(server.UUID, server.BackupID), archive.Path)
+	if err != nil {
+		log.Printf("Storing backup %d for server %d failed: %v", server.BackupID, server.ServerID, err)
+		os.Remove(archive.Path)
+		result["error"] = err.Error()
+		rl.publishBackupStatus(ctx, result)
+		return
+	}
+
+	log.Printf("Backup %d stored at %s (%d bytes)", server.BackupID, location, archive.Size)
+
+	result["success"] = true
+	result["path"] = location
+	result["size"] = archive.Size
+	result["checksum"] = archive.Checksum
+	rl.publishBackupStatus(ctx, result)
+}
+
+func (rl *RedisListener) handleRestore(ctx context.Context, server ServerConfig) {
+	serverID := server.ServerID
+	log.Printf("Restoring backup %d for server %d", server.BackupID, serverID)
+
+	if server.BackupID == 0 || server.UUID == "" {
+		rl.publishRestoreStatus(ctx, server, "failed", "restore request is missing backup_id or uuid")
+		return
+	}
+
+	archive, _, err := rl.storage.Open(ctx, backup.Key(server.UUID, server.BackupID))
+	if err != nil {
+		rl.publishRestoreStatus(ctx, server, "failed", fmt.Sprintf("backup archive unavailable: %v", err))
+		return
+	}
+	defer archive.Close()
+
+	containers, err := rl.dockerClient.ListContainers(ctx, map[string]string{
+		"server.id": fmt.Sprintf("%d", serverID),
+	})
+	if err != nil {
+		rl.publishRestoreStatus(ctx, server, "failed", fmt.Sprintf("failed to list containers: %v", err))
+		return
+	}
+
+	if len(containers) > 0 && containers[0].State == "running" {
+		rl.publishRestoreStatus(ctx, server, "stopping", "")
+		timeout := 30
+		if err := rl.dockerClient.StopContainer(ctx, containers[0].ID, &timeout); err != nil {
+			rl.publishRestoreStatus(ctx, server, "failed", fmt.Sprintf("failed to stop container: %v", err))
+			return
+		}
+		rl.publishStatus(ctx, serverID, "offline", "")
+	}
+
+	dataDir := rl.dataDir(server.UUID)
+	if server.Truncate {
+		rl.publishRestoreStatus(ctx, server, "wiping", "")
+		if err := backup.Wipe(dataDir); err != nil {
+			rl.publishRestoreStatus(ctx, server, "failed", err.Error())
+			return
+		}
+	}
+
+	rl.publishRestoreStatus(ctx, server, "extracting", "")
+	if err := backup.Extract(archive, dataDir); err != nil {
+		log.Printf("Restore of backup %d for server %d failed: %v", server.BackupID, serverID, err)
+		rl.publishRestoreStatus(ctx, server, "failed", err.Error())
+		return
+	}
+
+	rl.publishRestoreStatus(ctx, server, "starting", "")
+	if err := rl.handleStart(ctx, server); err != nil {
+		rl.publishRestoreStatus(ctx, server, "failed", err.Error())
+		return
+	}
+
+	rl.publishRestoreStatus(ctx, server, "completed", "")
+}
+
+func (rl *RedisListener) handleBackupDelete(ctx context.Context, server ServerConfig) {
+	if server.BackupID == 0 || server.UUID == "" {
+		log.Printf("Backup delete request for server %d is missing backup_id or uuid", server.ServerID)
+		return
+	}
+
+	key := backup.Key(server.UUID, server.BackupID)
+	if err := rl.storage.Delete(ctx, key); err != nil {
+		log.Printf("Failed to delete backup %s: %v", key, err)
+		return
+	}
+	log.Printf("Deleted backup %s", key)
+}
+
+func (rl *RedisListener) publishBackupStatus(ctx context.Context, result map[string]interface{}) {
+	rl.publish(ctx, "backup:status", result)
+}
+
+// publishRestoreStatus reports restore progress. The stage is one of
+// stopping, wiping, extracting, starting, completed or failed.
+func (rl *RedisListener) publishRestoreStatus(ctx context.Context, server ServerConfig, stage string, errMsg string) {
+	event := map[string]interface{}{
+		"server_id": server.ServerID,
+		"backup_id": server.BackupID,
+		"stage":     stage,
+	}
+	if errMsg != "" {
+		event["error"] = errMsg
+	}
+	rl.publish(ctx, "backup:restore", event)
+}
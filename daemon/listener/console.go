@@ -0,0 +1,159 @@
+package listener
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// maxConsoleLine caps a single console line so a process that never prints a
+// newline can't grow the buffer without bound.
+const maxConsoleLine = 4096
+
+type consoleStream struct {
+	cancel context.CancelFunc
+}
+
+// attachRunningConsoles follows the output of every managed container that
+// is already running, e.g. after the daemon restarts.
+func (rl *RedisListener) attachRunningConsoles(ctx context.Context) {
+	containers, err := rl.dockerClient.ListContainers(ctx, map[string]string{
+		"server.id": "",
+	})
+	if err != nil {
+		log.Printf("Error listing containers for console attach: %v", err)
+		return
+	}
+
+	for _, c := range containers {
+		if c.State != "running" {
+			continue
+		}
+		serverID, err := strconv.ParseUint(c.Labels["server.id"], 10, 64)
+		if err != nil {
+			continue
+		}
+		rl.followConsole(ctx, uint(serverID), c.ID, time.Now())
+	}
+}
+
+// followConsole streams a container's stdout and stderr written after since
+// to server:console until the container stops. Calling it for a server that
+// is already being followed replaces the previous stream.
+func (rl *RedisListener) followConsole(ctx context.Context, serverID uint, containerID string, since time.Time) {
+	streamCtx, cancel := context.WithCancel(ctx)
+	stream := &consoleStream{cancel: cancel}
+
+	rl.consoleMu.Lock()
+	if previous, ok := rl.consoles[serverID]; ok {
+		previous.cancel()
+	}
+	rl.consoles[serverID] = stream
+	rl.consoleMu.Unlock()
+
+	go func() {
+		defer func() {
+			cancel()
+			rl.consoleMu.Lock()
+			// Only clear the entry if a newer stream hasn't replaced it
+			if rl.consoles[serverID] == stream {
+				delete(rl.consoles, serverID)
+			}
+			rl.consoleMu.Unlock()
+		}()
+
+		logs, err := rl.dockerClient.GetContainerLogs(streamCtx, containerID, types.ContainerLogsOptions{
+			ShowStdout: true,
+			ShowStderr: true,
+			Follow:     true,
+			Since:      fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond()),
+		})
+		if err != nil {
+			log.Printf("Error following logs for server %d: %v", serverID, err)
+			return
+		}
+		defer logs.Close()
+
+		stdout := &lineWriter{publish: func(line string) { rl.publishConsole(streamCtx, serverID, "stdout", line) }}
+		stderr := &lineWriter{publish: func(line string) { rl.publishConsole(streamCtx, serverID, "stderr", line) }}
+
+		// Containers run without a TTY, so Docker multiplexes both streams
+		// into one with an 8 byte header per frame
+		if _, err := stdcopy.StdCopy(stdout, stderr, logs); err != nil && streamCtx.Err() == nil {
+			log.Printf("Console stream for server %d ended: %v", serverID, err)
+		}
+		stdout.Flush()
+		stderr.Flush()
+	}()
+}
+
+// handleCommand writes a console command to the server's stdin.
+func (rl *RedisListener) handleCommand(ctx context.Context, server ServerConfig) {
+	serverID := server.ServerID
+	if server.Command == "" {
+		return
+	}
+
+	containers, err := rl.dockerClient.ListContainers(ctx, map[string]string{
+		"server.id": fmt.Sprintf("%d", serverID),
+	})
+	if err != nil {
+		log.Printf("Error listing containers: %v", err)
+		return
+	}
+
+	if len(containers) == 0 || containers[0].State != "running" {
+		rl.publishConsole(ctx, serverID, "daemon", "Server is not running, command not sent")
+		return
+	}
+
+	if err := rl.dockerClient.WriteStdin(ctx, containers[0].ID, []byte(server.Command+"\n")); err != nil {
+		log.Printf("Error sending command to server %d: %v", serverID, err)
+		rl.publishConsole(ctx, serverID, "daemon", "Failed to send command to the server")
+	}
+}
+
+func (rl *RedisListener) publishConsole(ctx context.Context, serverID uint, stream, line string) {
+	rl.publish(ctx, "server:console", map[string]interface{}{
+		"server_id": serverID,
+		"stream":    stream,
+		"line":      line,
+	})
+}
+
+// lineWriter splits a byte stream into lines and publishes each one.
+type lineWriter struct {
+	buf     bytes.Buffer
+	publish func(line string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		data := w.buf.Bytes()
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			if w.buf.Len() > maxConsoleLine {
+				w.publish(string(w.buf.Next(maxConsoleLine)))
+				continue
+			}
+			return len(p), nil
+		}
+		line := w.buf.Next(i + 1)
+		w.publish(string(bytes.TrimRight(line, "\r\n")))
+	}
+}
+
+// Flush publishes any trailing output that didn't end with a newline.
+func (w *lineWriter) Flush() {
+	if w.buf.Len() > 0 {
+		w.publish(w.buf.String())
+		w.buf.Reset()
+	}
+}
@@ -0,0 +1,150 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-connections/nat"
+)
+
+// ServerConfig is the server description the backend sends along with power
+// actions and backup jobs, so the daemon never needs database access.
+type ServerConfig struct {
+	ServerID    uint   `json:"server_id"`
+	UUID        string `json:"uuid"`
+	DockerImage string `json:"docker_image"`
+	MemoryLimit int64  `json:"memory_limit"` // bytes
+	CPULimit    int64  `json:"cpu_limit"`    // nano CPUs
+	DiskLimit   int64  `json:"disk_limit"`   // bytes
+	IP          string `json:"ip"`
+	Port        int    `json:"port"`
+
+	// Set on backup jobs. Truncate wipes the data directory before a restore.
+	BackupID uint `json:"backup_id,omitempty"`
+	Truncate bool `json:"truncate,omitempty"`
+
+	// Set on server:command requests
+	Command string `json:"command,omitempty"`
+}
+
+func (s ServerConfig) validate() error {
+	switch {
+	case s.UUID == "":
+		return errors.New("missing server uuid")
+	case s.DockerImage == "":
+		return errors.New("missing docker image")
+	case s.Port <= 0 || s.Port > 65535:
+		return fmt.Errorf("invalid allocation port %d", s.Port)
+	case s.MemoryLimit < 0 || s.CPULimit < 0 || s.DiskLimit < 0:
+		return errors.New("resource limits must not be negative")
+	}
+	return nil
+}
+
+// dataDir is the host directory mounted into the server's container.
+func (rl *RedisListener) dataDir(serverUUID string) string {
+	return filepath.Join(rl.cfg.DataDir, serverUUID)
+}
+
+// createContainer pulls the server's image and creates its container with
+// the allocation bound and resource limits applied. It returns the new
+// container's ID.
+func (rl *RedisListener) createContainer(ctx context.Context, server ServerConfig) (string, error) {
+	if err := server.validate(); err != nil {
+		return "", fmt.Errorf("invalid server configuration: %w", err)
+	}
+
+	dataDir := rl.dataDir(server.UUID)
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	if err := rl.dockerClient.PullImage(ctx, server.DockerImage); err != nil {
+		return "", err
+	}
+
+	port := strconv.Itoa(server.Port)
+	exposedPorts := nat.PortSet{}
+	portBindings := nat.PortMap{}
+	for _, proto := range []string{"tcp", "udp"} {
+		containerPort := nat.Port(port + "/" + proto)
+		exposedPorts[containerPort] = struct{}{}
+		portBindings[containerPort] = []nat.PortBinding{{HostIP: server.IP, HostPort: port}}
+	}
+
+	config := &container.Config{
+		Image:        server.DockerImage,
+		ExposedPorts: exposedPorts,
+		// Keep stdin open so console commands can be written to the server
+		OpenStdin:   true,
+		AttachStdin: true,
+		Env: []string{
+			"SERVER_IP=" + server.IP,
+			"SERVER_PORT=" + port,
+			fmt.Sprintf("SERVER_MEMORY=%d", server.MemoryLimit/1024/1024),
+		},
+		Labels: map[string]string{
+			"server.id":         fmt.Sprintf("%d", server.ServerID),
+			"server.uuid":       server.UUID,
+			"server.disk_limit": fmt.Sprintf("%d", server.DiskLimit),
+		},
+	}
+
+	hostConfig := &container.HostConfig{
+		PortBindings: portBindings,
+		Mounts: []mount.Mount{{
+			Type:   mount.TypeBind,
+			Source: dataDir,
+			Target: "/home/container",
+		}},
+		Resources: container.Resources{
+			Memory:   server.MemoryLimit,
+			NanoCPUs: server.CPULimit,
+		},
+		RestartPolicy: container.RestartPolicy{Name: "no"},
+	}
+
+	return rl.dockerClient.CreateContainer(ctx, config, hostConfig, fmt.Sprintf("game-server-%d", server.ServerID))
+}
+
+// handleDelete removes a deleted server's container, stopping it if need be,
+// and its data directory. The panel sends a server:backup:delete job for each
+// of its backups alongside.
+func (rl *RedisListener) handleDelete(ctx context.Context, server ServerConfig) {
+	serverID := server.ServerID
+	log.Printf("Deleting server %d", serverID)
+
+	containers, err := rl.dockerClient.ListContainers(ctx, map[string]string{
+		"server.id": fmt.Sprintf("%d", serverID),
+	})
+	if err != nil {
+		log.Printf("Error listing containers of server %d: %v", serverID, err)
+		return
+	}
+	for _, c := range containers {
+		if err := rl.dockerClient.RemoveContainer(ctx, c.ID, true); err != nil {
+			log.Printf("Error removing container %s: %v", c.ID, err)
+			return
+		}
+		log.Printf("Container %s removed", c.ID)
+	}
+
+	// The UUID comes from the panel, but make sure it can only name a
+	// directory directly under DATA_DIR
+	if server.UUID == "" || server.UUID != filepath.Base(server.UUID) || server.UUID == "." || server.UUID == ".." {
+		log.Printf("Not removing data of server %d: invalid UUID %q", serverID, server.UUID)
+		return
+	}
+	if err := os.RemoveAll(rl.dataDir(server.UUID)); err != nil {
+		log.Printf("Error removing data of server %d: %v", serverID, err)
+		return
+	}
+	log.Printf("Server %d deleted", serverID)
+}
@@ -0,0 +1,75 @@
+package listener
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"gaming-panel/daemon/disk"
+)
+
+// diskCheckInterval is how often running servers are checked against their
+// disk limits. Data lives on a bind mount, which Docker can't put a quota
+// on, so limits are enforced by measuring it.
+const diskCheckInterval = time.Minute
+
+// checkDiskLimit refuses to start a server whose data has outgrown its disk
+// limit. A limit of zero means unlimited.
+func (rl *RedisListener) checkDiskLimit(server ServerConfig) error {
+	if server.DiskLimit <= 0 {
+		return nil
+	}
+
+	used, err := disk.Usage(rl.dataDir(server.UUID))
+	if err != nil {
+		return fmt.Errorf("failed to measure disk usage: %w", err)
+	}
+	if used >= server.DiskLimit {
+		return fmt.Errorf("disk limit exceeded: %d of %d bytes used", used, server.DiskLimit)
+	}
+	return nil
+}
+
+// enforceDiskLimits stops running servers that outgrow their disk limit,
+// until ctx is cancelled.
+func (rl *RedisListener) enforceDiskLimits(ctx context.Context) {
+	ticker := time.NewTicker(diskCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		containers, err := rl.dockerClient.ListContainers(ctx, map[string]string{
+			"server.id": "",
+		})
+		if err != nil {
+			log.Printf("Error listing containers for disk limits: %v", err)
+			continue
+		}
+
+		for _, c := range containers {
+			limit, _ := strconv.ParseInt(c.Labels["server.disk_limit"], 10, 64)
+			serverID, err := strconv.ParseUint(c.Labels["server.id"], 10, 64)
+			if c.State != "running" || limit <= 0 || err != nil {
+				continue
+			}
+
+			server := ServerConfig{ServerID: uint(serverID), UUID: c.Labels["server.uuid"], DiskLimit: limit}
+			if err := rl.checkDiskLimit(server); err != nil {
+				log.Printf("Stopping server %d: %v", serverID, err)
+				timeout := 10
+				if err := rl.dockerClient.StopContainer(ctx, c.ID, &timeout); err != nil {
+					log.Printf("Error stopping container %s: %v", c.ID, err)
+					continue
+				}
+				rl.publishStatus(ctx, server.ServerID, "offline", err.Error())
+			}
+		}
+	}
+}
@@ -6,42 +6,80 @@ import (
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"gaming-panel/daemon/backup"
+	"gaming-panel/daemon/config"
 	"gaming-panel/daemon/docker"
+	"gaming-panel/daemon/panel"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// jobChannels are the kinds of job the daemon takes from the panel.
+var jobChannels = []string{
+	"server:start",
+	"server:stop",
+	"server:restart",
+	"server:backup",
+	"server:restore",
+	"server:backup:delete",
+	"server:command",
+	"server:delete",
+}
+
 type RedisListener struct {
+	cfg          *config.Config
+	prefix       string // of the node's job channels
 	redisClient  *redis.Client
 	dockerClient *docker.Client
+	storage      backup.Storage
 	pubsub       *redis.PubSub
+
+	consoleMu sync.Mutex
+	consoles  map[uint]*consoleStream
+
+	// Signatures of the jobs taken recently, so a job can't be replayed
+	// while its timestamp is still accepted
+	seenMu sync.Mutex
+	seen   map[string]time.Time
 }
 
-func NewRedisListener(redisURL string, dockerClient *docker.Client) *RedisListener {
-	opt, err := redis.ParseURL(redisURL)
+func NewRedisListener(cfg *config.Config, dockerClient *docker.Client, storage backup.Storage) *RedisListener {
+	opt, err := redis.ParseURL(cfg.RedisURL)
 	if err != nil {
 		log.Fatalf("Failed to parse Redis URL: %v", err)
 	}
 
+	// The panel sends each node's jobs on channels of its own
 	client := redis.NewClient(opt)
-	pubsub := client.Subscribe(context.Background(),
-		"server:start",
-		"server:stop",
-		"server:restart",
-		"server:backup",
-	)
+	prefix := fmt.Sprintf("node:%d:", cfg.NodeID)
+	channels := make([]string, len(jobChannels))
+	for i, channel := range jobChannels {
+		channels[i] = prefix + channel
+	}
+	pubsub := client.Subscribe(context.Background(), channels...)
 
 	return &RedisListener{
+		cfg:          cfg,
+		prefix:       prefix,
 		redisClient:  client,
 		dockerClient: dockerClient,
+		storage:      storage,
 		pubsub:       pubsub,
+		consoles:     make(map[uint]*consoleStream),
+		seen:         make(map[string]time.Time),
 	}
 }
 
 func (rl *RedisListener) Start(ctx context.Context) {
 	ch := rl.pubsub.Channel()
 
+	rl.attachRunningConsoles(ctx)
+	go rl.enforceDiskLimits(ctx)
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -55,31 +93,119 @@ func (rl *RedisListener) Start(ctx context.Context) {
 }
 
 func (rl *RedisListener) handleMessage(ctx context.Context, msg *redis.Message) {
-	serverID, err := strconv.ParseUint(msg.Payload, 10, 64)
+	// Anyone who can reach Redis can publish, so only jobs the panel signed
+	// with this node's key are taken
+	message, err := panel.OpenMessage(rl.cfg.SigningKey(), msg.Channel, msg.Payload)
 	if err != nil {
-		log.Printf("Invalid server ID in message: %s", msg.Payload)
+		log.Printf("Rejected job on channel %s: %v", msg.Channel, err)
+		return
+	}
+	if !rl.firstSeen(message.Signature) {
+		log.Printf("Rejected replayed job on channel %s", msg.Channel)
 		return
 	}
 
+	server, err := parsePayload(message.Payload)
+	if err != nil {
+		log.Printf("Invalid payload on channel %s: %v", msg.Channel, err)
+		return
+	}
+	serverID := server.ServerID
+
 	log.Printf("Received message on channel %s for server %d", msg.Channel, serverID)
 
-	switch msg.Channel {
+	switch strings.TrimPrefix(msg.Channel, rl.prefix) {
 	case "server:start":
-		rl.handleStart(ctx, uint(serverID))
+		rl.handleStart(ctx, server)
 	case "server:stop":
-		rl.handleStop(ctx, uint(serverID))
+		rl.handleStop(ctx, serverID)
 	case "server:restart":
-		rl.handleRestart(ctx, uint(serverID))
+		rl.handleRestart(ctx, server)
 	case "server:backup":
-		rl.handleBackup(ctx, uint(serverID))
+		rl.handleBackup(ctx, server)
+	case "server:restore":
+		rl.handleRestore(ctx, server)
+	case "server:backup:delete":
+		rl.handleBackupDelete(ctx, server)
+	case "server:command":
+		rl.handleCommand(ctx, server)
+	case "server:delete":
+		rl.handleDelete(ctx, server)
 	}
 }
 
-func (rl *RedisListener) handleStart(ctx context.Context, serverID uint) {
-	log.Printf("Starting server %d", serverID)
+// firstSeen records a job's signature and reports whether it is new.
+func (rl *RedisListener) firstSeen(signature string) bool {
+	rl.seenMu.Lock()
+	defer rl.seenMu.Unlock()
+
+	now := time.Now()
+	for seen, at := range rl.seen {
+		if now.Sub(at) > 2*panel.MessageMaxAge {
+			delete(rl.seen, seen)
+		}
+	}
+	if _, ok := rl.seen[signature]; ok {
+		return false
+	}
+	rl.seen[signature] = now
+	return true
+}
+
+// parsePayload accepts either a bare server ID or a JSON ServerConfig.
+func parsePayload(payload string) (ServerConfig, error) {
+	var server ServerConfig
+	if id, err := strconv.ParseUint(payload, 10, 64); err == nil {
+		server.ServerID = uint(id)
+		return server, nil
+	}
 
-	// Get container name
-	containerName := fmt.Sprintf("game-server-%d", serverID)
+	if err := json.Unmarshal([]byte(payload), &server); err != nil {
+		return server, fmt.Errorf("malformed server config: %w", err)
+	}
+	if server.ServerID == 0 {
+		return server, fmt.Errorf("missing server_id")
+	}
+	return server, nil
+}
+
+// publishStatus reports a server's state back to the backend. A non-empty
+// errMsg explains why an action failed.
+func (rl *RedisListener) publishStatus(ctx context.Context, serverID uint, status string, errMsg string) {
+	// Jobs run concurrently, so their outcomes may reach the panel out of
+	// order; at tells it which came last
+	statusUpdate := map[string]interface{}{
+		"server_id": serverID,
+		"status":    status,
+		"at":        time.Now().UnixNano(),
+	}
+	if errMsg != "" {
+		statusUpdate["error"] = errMsg
+	}
+	rl.publish(ctx, "server:status", statusUpdate)
+}
+
+// publish sends an event to the panel, signed with the node's key so the
+// panel can tell it came from the node hosting the server.
+func (rl *RedisListener) publish(ctx context.Context, channel string, event interface{}) {
+	data, err := json.Marshal(event)
+	if err == nil {
+		var message string
+		if message, err = panel.SignMessage(rl.cfg.SigningKey(), channel, string(data)); err == nil {
+			err = rl.redisClient.Publish(ctx, channel, message).Err()
+		}
+	}
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Failed to publish %s event: %v", channel, err)
+	}
+}
+
+// handleStart starts the server's container, creating it first if needed. The
+// outcome is published on server:status and also returned for callers that
+// start a server as part of a larger job.
+func (rl *RedisListener) handleStart(ctx context.Context, server ServerConfig) error {
+	serverID := server.ServerID
+	log.Printf("Starting server %d", serverID)
 
 	// Check if container exists
 	containers, err := rl.dockerClient.ListContainers(ctx, map[string]string{
@@ -88,29 +214,43 @@ func (rl *RedisListener) handleStart(ctx context.Context, serverID uint) {
 
 	if err != nil {
 		log.Printf("Error listing containers: %v", err)
-		return
+		err = fmt.Errorf("failed to list containers: %w", err)
+		rl.publishStatus(ctx, serverID, "offline", err.Error())
+		return err
 	}
 
+	var containerID string
 	if len(containers) > 0 {
-		// Container exists, start it
-		err = rl.dockerClient.StartContainer(ctx, containers[0].ID)
+		containerID = containers[0].ID
+	} else {
+		log.Printf("Container not found for server %d, creating it", serverID)
+		containerID, err = rl.createContainer(ctx, server)
 		if err != nil {
-			log.Printf("Error starting container: %v", err)
-			return
+			log.Printf("Error creating container for server %d: %v", serverID, err)
+			rl.publishStatus(ctx, serverID, "offline", err.Error())
+			return err
 		}
-		log.Printf("Container %s started", containers[0].ID)
-	} else {
-		log.Printf("Container not found for server %d. Need to create it first.", serverID)
-		// TODO: Create container from server configuration
+		log.Printf("Container %s created", containerID)
 	}
 
-	// Publish status update
-	statusUpdate := map[string]interface{}{
-		"server_id": serverID,
-		"status":    "online",
+	if err := rl.checkDiskLimit(server); err != nil {
+		log.Printf("Not starting server %d: %v", serverID, err)
+		rl.publishStatus(ctx, serverID, "offline", err.Error())
+		return err
 	}
-	data, _ := json.Marshal(statusUpdate)
-	rl.redisClient.Publish(ctx, "server:status", string(data))
+
+	startedAt := time.Now()
+	if err := rl.dockerClient.StartContainer(ctx, containerID); err != nil {
+		log.Printf("Error starting container: %v", err)
+		err = fmt.Errorf("failed to start container: %w", err)
+		rl.publishStatus(ctx, serverID, "offline", err.Error())
+		return err
+	}
+	log.Printf("Container %s started", containerID)
+	rl.followConsole(ctx, serverID, containerID, startedAt)
+
+	rl.publishStatus(ctx, serverID, "online", "")
+	return nil
 }
 
 func (rl *RedisListener) handleStop(ctx context.Context, serverID uint) {
@@ -120,8 +260,16 @@ func (rl *RedisListener) handleStop(ctx context.Context, serverID uint) {
 		"server.id": fmt.Sprintf("%d", serverID),
 	})
 
-	if err != nil || len(containers) == 0 {
+	if err != nil {
+		log.Printf("Error listing containers: %v", err)
+		rl.publishStatus(ctx, serverID, "online", fmt.Sprintf("failed to list containers: %v", err))
+		return
+	}
+
+	if len(containers) == 0 {
+		// Never created, so there is nothing running to stop
 		log.Printf("Container not found for server %d", serverID)
+		rl.publishStatus(ctx, serverID, "offline", "")
 		return
 	}
 
@@ -129,54 +277,55 @@ func (rl *RedisListener) handleStop(ctx context.Context, serverID uint) {
 	err = rl.dockerClient.StopContainer(ctx, containers[0].ID, &timeout)
 	if err != nil {
 		log.Printf("Error stopping container: %v", err)
+		// The container is most likely still running
+		rl.publishStatus(ctx, serverID, "online", fmt.Sprintf("failed to stop container: %v", err))
 		return
 	}
 
 	log.Printf("Container %s stopped", containers[0].ID)
 
-	statusUpdate := map[string]interface{}{
-		"server_id": serverID,
-		"status":    "offline",
-	}
-	data, _ := json.Marshal(statusUpdate)
-	rl.redisClient.Publish(ctx, "server:status", string(data))
+	rl.publishStatus(ctx, serverID, "offline", "")
 }
 
-func (rl *RedisListener) handleRestart(ctx context.Context, serverID uint) {
+func (rl *RedisListener) handleRestart(ctx context.Context, server ServerConfig) {
+	serverID := server.ServerID
 	log.Printf("Restarting server %d", serverID)
 
 	containers, err := rl.dockerClient.ListContainers(ctx, map[string]string{
 		"server.id": fmt.Sprintf("%d", serverID),
 	})
 
-	if err != nil || len(containers) == 0 {
-		log.Printf("Container not found for server %d", serverID)
+	if err != nil {
+		log.Printf("Error listing containers: %v", err)
+		rl.publishStatus(ctx, serverID, "offline", fmt.Sprintf("failed to list containers: %v", err))
+		return
+	}
+
+	if len(containers) == 0 {
+		// Nothing to restart yet, so build the container and start it
+		rl.handleStart(ctx, server)
+		return
+	}
+
+	if err := rl.checkDiskLimit(server); err != nil {
+		log.Printf("Not restarting server %d: %v", serverID, err)
+		timeout := 10
+		rl.dockerClient.StopContainer(ctx, containers[0].ID, &timeout)
+		rl.publishStatus(ctx, serverID, "offline", err.Error())
 		return
 	}
 
 	timeout := 10
+	restartedAt := time.Now()
 	err = rl.dockerClient.RestartContainer(ctx, containers[0].ID, &timeout)
 	if err != nil {
 		log.Printf("Error restarting container: %v", err)
+		rl.publishStatus(ctx, serverID, "offline", fmt.Sprintf("failed to restart container: %v", err))
 		return
 	}
 
 	log.Printf("Container %s restarted", containers[0].ID)
+	rl.followConsole(ctx, serverID, containers[0].ID, restartedAt)
 
-	statusUpdate := map[string]interface{}{
-		"server_id": serverID,
-		"status":    "online",
-	}
-	data, _ := json.Marshal(statusUpdate)
-	rl.redisClient.Publish(ctx, "server:status", string(data))
-}
-
-func (rl *RedisListener) handleBackup(ctx context.Context, serverID uint) {
-	log.Printf("Creating backup for server %d", serverID)
-	// TODO: Implement backup logic
-	// This would typically involve:
-	// 1. Stop the server (if needed)
-	// 2. Create a tar archive of the server data directory
-	// 3. Upload to cloud storage (S3, etc.)
-	// 4. Restart the server (if it was stopped)
+	rl.publishStatus(ctx, serverID, "online", "")
 }
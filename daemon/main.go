@@ -7,34 +7,63 @@ import (
 	"os/signal"
 	"syscall"
 
+	"gaming-panel/daemon/api"
+	"gaming-panel/daemon/backup"
 	"gaming-panel/daemon/config"
 	"gaming-panel/daemon/docker"
+	"gaming-panel/daemon/heartbeat"
 	"gaming-panel/daemon/listener"
+	"gaming-panel/daemon/panel"
 )
 
+// version is reported to the panel. Release builds set it with
+// -ldflags "-X main.version=<version>".
+var version = "dev"
+
 func main() {
 	cfg := config.Load()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Jobs arrive on channels named after the node, so its identity is
+	// needed before anything else
+	if _, err := panel.Connect(ctx, cfg); err != nil {
+		log.Fatalf("Failed to authenticate with the panel: %v", err)
+	}
+
 	// Initialize Docker client
 	dockerClient, err := docker.NewClient()
 	if err != nil {
 		log.Fatalf("Failed to initialize Docker client: %v", err)
 	}
 
-	// Initialize Redis subscriber
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// Initialize backup storage
+	storage, err := backup.NewStorage(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize backup storage: %v", err)
+	}
 
-	redisListener := listener.NewRedisListener(cfg.RedisURL, dockerClient)
+	redisListener := listener.NewRedisListener(cfg, dockerClient, storage)
 	go redisListener.Start(ctx)
 
+	// Keeps the node marked online in the panel
+	go heartbeat.NewReporter(cfg, dockerClient, version).Start(ctx)
+
+	// HTTP server for signed backup downloads
+	apiServer := api.NewServer(cfg, storage)
+	go apiServer.Start(ctx)
+
 	// Graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
 	log.Println("🦖 Daemon started successfully")
-	log.Printf("Node ID: %s", cfg.NodeID)
+	log.Printf("Node ID: %d", cfg.NodeID)
+	log.Printf("Version: %s", version)
 	log.Printf("Listening on Redis: %s", cfg.RedisURL)
+	log.Printf("HTTP server listening on %s (TLS: %t)", cfg.ListenAddr, cfg.TLSCertFile != "" && cfg.TLSKeyFile != "")
+	log.Printf("Backup storage: %s", cfg.BackupStorage)
 
 	<-sigChan
 	log.Println("Shutting down daemon...")
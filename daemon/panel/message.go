@@ -0,0 +1,61 @@
+package panel
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MessageMaxAge is how far a signed message's timestamp may be from this
+// node's clock.
+const MessageMaxAge = 5 * time.Minute
+
+// ErrMessageInvalid means a message was malformed, stale or not signed with
+// the node's key.
+var ErrMessageInvalid = errors.New("invalid signed message")
+
+// Message is how jobs, heartbeats and events travel over Redis between the
+// panel and the daemon, signed with config.SigningKey. It must stay in sync
+// with the panel.
+type Message struct {
+	Payload   string `json:"payload"`
+	Timestamp int64  `json:"ts"`
+	Signature string `json:"sig"`
+}
+
+// SignMessage wraps payload for channel.
+func SignMessage(key []byte, channel, payload string) (string, error) {
+	msg := Message{Payload: payload, Timestamp: time.Now().Unix()}
+	msg.Signature = messageSignature(key, channel, msg.Timestamp, payload)
+	data, err := json.Marshal(msg)
+	return string(data), err
+}
+
+// OpenMessage checks that data was signed with key for channel recently and
+// returns it.
+func OpenMessage(key []byte, channel, data string) (Message, error) {
+	var msg Message
+	if err := json.Unmarshal([]byte(data), &msg); err != nil || msg.Signature == "" {
+		return msg, ErrMessageInvalid
+	}
+
+	age := time.Since(time.Unix(msg.Timestamp, 0))
+	if age > MessageMaxAge || age < -MessageMaxAge {
+		return msg, ErrMessageInvalid
+	}
+	expected := messageSignature(key, channel, msg.Timestamp, msg.Payload)
+	if !hmac.Equal([]byte(expected), []byte(msg.Signature)) {
+		return msg, ErrMessageInvalid
+	}
+	return msg, nil
+}
+
+func messageSignature(key []byte, channel string, timestamp int64, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%d\n%s", channel, timestamp, payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
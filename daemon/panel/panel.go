@@ -0,0 +1,206 @@
+// Package panel talks to the panel's daemon API, which authenticates the
+// node by the token it received when it enrolled.
+package panel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gaming-panel/daemon/config"
+)
+
+// retryInterval is how long to wait before enrolling again when the panel is
+// unreachable, e.g. while it is still starting.
+const retryInterval = 5 * time.Second
+
+// ErrUnauthorized means the panel rejected the node's credentials.
+var ErrUnauthorized = errors.New("the panel rejected the node's credentials")
+
+// Node is the panel's record of this node.
+type Node struct {
+	ID          uint   `json:"id"`
+	Name        string `json:"name"`
+	Maintenance bool   `json:"maintenance"`
+}
+
+// credentials are kept in CREDENTIALS_FILE between runs.
+type credentials struct {
+	NodeID uint   `json:"node_id"`
+	Token  string `json:"token"`
+}
+
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func newClient(baseURL, token string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Connect authenticates the daemon with the panel and sets cfg.NodeID and
+// cfg.NodeToken. A node without credentials, or whose credentials the panel
+// rejects, enrolls with ENROLLMENT_TOKEN and saves the credentials it gets
+// to CREDENTIALS_FILE. A panel that can't be reached is no reason to stop a
+// node that is already enrolled.
+func Connect(ctx context.Context, cfg *config.Config) (*Client, error) {
+	creds, err := readCredentials(cfg.CredentialsFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if err == nil {
+		client := newClient(cfg.APIURL, creds.Token)
+		_, err := client.Node(ctx)
+		if err == nil || !errors.Is(err, ErrUnauthorized) {
+			if err != nil {
+				log.Printf("Could not reach the panel, continuing as node %d: %v", creds.NodeID, err)
+			}
+			cfg.NodeID, cfg.NodeToken = creds.NodeID, creds.Token
+			return client, nil
+		}
+		if cfg.EnrollmentToken == "" {
+			return nil, fmt.Errorf("%w; issue a new enrollment token for this node and set ENROLLMENT_TOKEN", err)
+		}
+		log.Printf("The panel rejected the saved credentials, enrolling again")
+	}
+
+	if cfg.EnrollmentToken == "" {
+		return nil, errors.New("the node is not enrolled; set ENROLLMENT_TOKEN to the token shown when the node was created")
+	}
+
+	for {
+		creds, err = enroll(ctx, cfg.APIURL, cfg.EnrollmentToken)
+		if err == nil {
+			break
+		}
+		if errors.Is(err, ErrUnauthorized) {
+			return nil, fmt.Errorf("enrollment failed: %w; the token may have expired or been used already", err)
+		}
+
+		log.Printf("Enrollment failed, retrying in %s: %v", retryInterval, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retryInterval):
+		}
+	}
+
+	if err := writeCredentials(cfg.CredentialsFile, creds); err != nil {
+		return nil, fmt.Errorf("failed to save credentials: %w", err)
+	}
+	log.Printf("Enrolled as node %d", creds.NodeID)
+
+	cfg.NodeID, cfg.NodeToken = creds.NodeID, creds.Token
+	return newClient(cfg.APIURL, creds.Token), nil
+}
+
+// enroll exchanges a one-time enrollment token for the node's credentials.
+func enroll(ctx context.Context, apiURL, enrollmentToken string) (credentials, error) {
+	var response struct {
+		NodeID uint   `json:"node_id"`
+		Token  string `json:"token"`
+	}
+	err := newClient(apiURL, "").do(ctx, http.MethodPost, "/daemon/enroll", map[string]string{
+		"token": enrollmentToken,
+	}, &response)
+	return credentials{NodeID: response.NodeID, Token: response.Token}, err
+}
+
+// Node fetches the panel's record of this node.
+func (c *Client) Node(ctx context.Context) (Node, error) {
+	var node Node
+	err := c.do(ctx, http.MethodGet, "/daemon/node", nil, &node)
+	return node, err
+}
+
+// do sends body as JSON and decodes the response into out.
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return ErrUnauthorized
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&apiErr)
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, apiErr.Error)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func readCredentials(path string) (credentials, error) {
+	var creds credentials
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return creds, err
+	}
+	if err := json.Unmarshal(data, &creds); err != nil || creds.NodeID == 0 || creds.Token == "" {
+		return creds, fmt.Errorf("invalid credentials file %s", path)
+	}
+	return creds, nil
+}
+
+// writeCredentials saves the credentials readable by the daemon's user only,
+// replacing the file atomically.
+func writeCredentials(path string, creds credentials) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -1,6 +1,7 @@
 'use client'
 
-import { useState } from 'react'
+import { useEffect, useState } from 'react'
+import Link from 'next/link'
 import { useRouter } from 'next/navigation'
 import { useAuthStore } from '@/store/auth'
 import api from '@/lib/api'
@@ -10,8 +11,32 @@ export default function LoginPage() {
   const { setAuth } = useAuthStore()
   const [email, setEmail] = useState('')
   const [password, setPassword] = useState('')
+  const [challengeToken, setChallengeToken] = useState('')
+  const [code, setCode] = useState('')
   const [error, setError] = useState('')
   const [loading, setLoading] = useState(false)
+  const [sso, setSso] = useState<{ enabled: boolean; name: string } | null>(null)
+
+  useEffect(() => {
+    api.get('/auth/oidc').then((response) => setSso(response.data)).catch(() => {})
+
+    // Single sign-on logins that need a second factor finish here
+    const pending = sessionStorage.getItem('challenge_token')
+    if (pending) {
+      sessionStorage.removeItem('challenge_token')
+      setChallengeToken(pending)
+    }
+  }, [])
+
+  const handleSso = async () => {
+    setError('')
+    try {
+      const response = await api.get('/auth/oidc/authorize')
+      window.location.href = response.data.authorization_url
+    } catch (err: any) {
+      setError(err.response?.data?.error || 'Single sign-on is unavailable')
+    }
+  }
 
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault()
@@ -19,7 +44,17 @@ export default function LoginPage() {
     setLoading(true)
 
     try {
-      const response = await api.post('/auth/login', { email, password })
+      const response = challengeToken
+        ? await api.post('/auth/2fa/verify', code.includes('-')
+            ? { challenge_token: challengeToken, recovery_code: code }
+            : { challenge_token: challengeToken, code })
+        : await api.post('/auth/login', { email, password })
+
+      if (response.data.two_factor_required) {
+        setChallengeToken(response.data.challenge_token)
+        return
+      }
+
       const { token, refresh_token, user } = response.data
 
       setAuth(token, refresh_token, user)
@@ -50,46 +85,84 @@ export default function LoginPage() {
               </div>
             )}
 
-            <div>
-              <label htmlFor="email" className="block text-sm font-medium text-gray-300 mb-2">
-                Email Address
-              </label>
-              <input
-                id="email"
-                name="email"
-                type="email"
-                required
-                value={email}
-                onChange={(e) => setEmail(e.target.value)}
-                className="w-full px-4 py-3 bg-[#0f172a] border border-[#334155] text-white rounded-lg focus:outline-none focus:ring-2 focus:ring-[#0ea5e9] focus:border-transparent transition-all placeholder-gray-500"
-                placeholder="you@example.com"
-              />
-            </div>
-
-            <div>
-              <label htmlFor="password" className="block text-sm font-medium text-gray-300 mb-2">
-                Password
-              </label>
-              <input
-                id="password"
-                name="password"
-                type="password"
-                required
-                value={password}
-                onChange={(e) => setPassword(e.target.value)}
-                className="w-full px-4 py-3 bg-[#0f172a] border border-[#334155] text-white rounded-lg focus:outline-none focus:ring-2 focus:ring-[#0ea5e9] focus:border-transparent transition-all placeholder-gray-500"
-                placeholder="••••••••"
-              />
-            </div>
+            {challengeToken ? (
+              <div>
+                <label htmlFor="code" className="block text-sm font-medium text-gray-300 mb-2">
+                  Authentication Code
+                </label>
+                <input
+                  id="code"
+                  name="code"
+                  type="text"
+                  inputMode="numeric"
+                  autoComplete="one-time-code"
+                  required
+                  autoFocus
+                  value={code}
+                  onChange={(e) => setCode(e.target.value.trim())}
+                  className="w-full px-4 py-3 bg-[#0f172a] border border-[#334155] text-white rounded-lg focus:outline-none focus:ring-2 focus:ring-[#0ea5e9] focus:border-transparent transition-all placeholder-gray-500"
+                  placeholder="123456 or a recovery code"
+                />
+              </div>
+            ) : (
+              <>
+                <div>
+                  <label htmlFor="email" className="block text-sm font-medium text-gray-300 mb-2">
+                    Email Address
+                  </label>
+                  <input
+                    id="email"
+                    name="email"
+                    type="email"
+                    required
+                    value={email}
+                    onChange={(e) => setEmail(e.target.value)}
+                    className="w-full px-4 py-3 bg-[#0f172a] border border-[#334155] text-white rounded-lg focus:outline-none focus:ring-2 focus:ring-[#0ea5e9] focus:border-transparent transition-all placeholder-gray-500"
+                    placeholder="you@example.com"
+                  />
+                </div>
+
+                <div>
+                  <label htmlFor="password" className="block text-sm font-medium text-gray-300 mb-2">
+                    Password
+                  </label>
+                  <input
+                    id="password"
+                    name="password"
+                    type="password"
+                    required
+                    value={password}
+                    onChange={(e) => setPassword(e.target.value)}
+                    className="w-full px-4 py-3 bg-[#0f172a] border border-[#334155] text-white rounded-lg focus:outline-none focus:ring-2 focus:ring-[#0ea5e9] focus:border-transparent transition-all placeholder-gray-500"
+                    placeholder="••••••••"
+                  />
+                  <div className="mt-2 text-right text-sm">
+                    <Link href="/auth/forgot-password" className="text-[#0ea5e9] hover:underline">
+                      Forgot password?
+                    </Link>
+                  </div>
+                </div>
+              </>
+            )}
 
             <button
               type="submit"
               disabled={loading}
               className="w-full bg-[#0ea5e9] hover:bg-[#0284c7] text-white font-semibold py-3 px-4 rounded-lg transition-colors disabled:opacity-50 disabled:cursor-not-allowed"
             >
-              {loading ? 'Signing in...' : 'Sign In'}
+              {loading ? 'Signing in...' : challengeToken ? 'Verify' : 'Sign In'}
             </button>
           </form>
+
+          {sso?.enabled && !challengeToken && (
+            <button
+              type="button"
+              onClick={handleSso}
+              className="mt-4 w-full bg-[#0f172a] border border-[#334155] hover:border-[#0ea5e9] text-white font-semibold py-3 px-4 rounded-lg transition-colors"
+            >
+              Sign in with {sso.name}
+            </button>
+          )}
         </div>
       </div>
     </div>
@@ -0,0 +1,59 @@
+'use client'
+
+import { useEffect, useRef, useState } from 'react'
+import Link from 'next/link'
+import { useRouter } from 'next/navigation'
+import { useAuthStore } from '@/store/auth'
+import api from '@/lib/api'
+
+export default function OidcCallbackPage() {
+  const router = useRouter()
+  const { setAuth } = useAuthStore()
+  const [error, setError] = useState('')
+  // The state is single use, so the exchange must only run once
+  const started = useRef(false)
+
+  useEffect(() => {
+    if (started.current) return
+    started.current = true
+
+    const params = new URLSearchParams(window.location.search)
+    if (params.get('error')) {
+      setError(params.get('error_description') || params.get('error') || 'Sign in was cancelled')
+      return
+    }
+
+    api.post('/auth/oidc/callback', { code: params.get('code'), state: params.get('state') })
+      .then((response) => {
+        if (response.data.two_factor_required) {
+          sessionStorage.setItem('challenge_token', response.data.challenge_token)
+          router.replace('/auth/login')
+          return
+        }
+
+        const { token, refresh_token, user } = response.data
+        setAuth(token, refresh_token, user)
+        router.replace('/dashboard')
+      })
+      .catch((err) => setError(err.response?.data?.error || 'Single sign-on failed'))
+  }, [router, setAuth])
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gradient-to-br from-[#0f172a] to-[#1e293b]">
+      <div className="w-full max-w-md p-8">
+        <div className="bg-[#1e293b] border border-[#334155] rounded-2xl shadow-soft p-8 text-center">
+          {error ? (
+            <>
+              <p className="text-red-400">{error}</p>
+              <p className="mt-6 text-sm text-gray-400">
+                <Link href="/auth/login" className="text-[#0ea5e9] hover:underline">Back to sign in</Link>
+              </p>
+            </>
+          ) : (
+            <p className="text-gray-400">Signing you in...</p>
+          )}
+        </div>
+      </div>
+    </div>
+  )
+}
@@ -0,0 +1,37 @@
+'use client'
+
+import { useEffect, useState } from 'react'
+import Link from 'next/link'
+import api from '@/lib/api'
+
+export default function VerifyEmailPage() {
+  const [status, setStatus] = useState<'verifying' | 'verified' | 'failed'>('verifying')
+  const [error, setError] = useState('')
+
+  useEffect(() => {
+    const token = new URLSearchParams(window.location.search).get('token') || ''
+    api.post('/auth/email/verify', { token })
+      .then(() => setStatus('verified'))
+      .catch((err) => {
+        setError(err.response?.data?.error || 'Failed to verify email')
+        setStatus('failed')
+      })
+  }, [])
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gradient-to-br from-[#0f172a] to-[#1e293b]">
+      <div className="w-full max-w-md p-8">
+        <div className="bg-[#1e293b] border border-[#334155] rounded-2xl shadow-soft p-8 text-center">
+          <h1 className="text-3xl font-bold text-white mb-4">Verify Email</h1>
+          {status === 'verifying' && <p className="text-gray-400">Verifying your email...</p>}
+          {status === 'verified' && <p className="text-green-400">Your email is verified.</p>}
+          {status === 'failed' && <p className="text-red-400">{error}</p>}
+
+          <p className="mt-6 text-sm text-gray-400">
+            <Link href="/auth/login" className="text-[#0ea5e9] hover:underline">Go to sign in</Link>
+          </p>
+        </div>
+      </div>
+    </div>
+  )
+}
@@ -50,12 +50,19 @@ export default function DashboardPage() {
 
   const fetchData = async () => {
     try {
-      const [serversRes, statsRes] = await Promise.all([
+      const [serversRes, statsRes] = await Promise.allSettled([
         api.get('/servers'),
         api.get('/admin/metrics'),
       ])
-      setServers(serversRes.data)
-      setStats(statsRes.data)
+      if (serversRes.status === 'fulfilled') {
+        setServers(serversRes.value.data)
+      } else {
+        console.error('Failed to fetch servers:', serversRes.reason)
+      }
+      // Metrics are only available to roles with admin.metrics
+      if (statsRes.status === 'fulfilled') {
+        setStats(statsRes.value.data)
+      }
     } catch (error) {
       console.error('Failed to fetch data:', error)
     } finally {
@@ -6,6 +6,7 @@ import { Terminal } from '@xterm/xterm'
 import { FitAddon } from '@xterm/addon-fit'
 import '@xterm/xterm/css/xterm.css'
 import api from '@/lib/api'
+import { useAuthStore } from '@/store/auth'
 import ResourceChart from '@/components/ResourceChart'
 
 interface Server {
@@ -28,6 +29,7 @@ export default function ServerDetailPage() {
   const params = useParams()
   const router = useRouter()
   const serverId = params.id as string
+  const token = useAuthStore((state) => state.token)
   const [server, setServer] = useState<Server | null>(null)
   const [loading, setLoading] = useState(true)
   const [metrics, setMetrics] = useState({
@@ -78,25 +80,6 @@ export default function ServerDetailPage() {
       terminal.current.loadAddon(fitAddon.current)
       terminal.current.open(terminalRef.current)
       fitAddon.current.fit()
-
-      // Connect WebSocket
-      const wsUrl = `${process.env.NEXT_PUBLIC_WS_URL?.replace('http', 'ws') || 'ws://localhost:3000'}/ws`
-      const ws = new WebSocket(wsUrl)
-      
-      ws.onopen = () => {
-        ws.send(JSON.stringify({ server_id: server?.uuid }))
-        terminal.current?.write('Connected to server console\r\n')
-        terminal.current?.write('Type a command...\r\n\r\n')
-      }
-
-      ws.onmessage = (event) => {
-        const data = JSON.parse(event.data)
-        if (data.type === 'console') {
-          terminal.current?.write(data.message)
-        }
-      }
-
-      wsRef.current = ws
     }
 
     // Fetch metrics every 5 seconds
@@ -104,11 +87,39 @@ export default function ServerDetailPage() {
 
     return () => {
       clearInterval(metricsInterval)
-      wsRef.current?.close()
       terminal.current?.dispose()
     }
   }, [serverId])
 
+  // Connect the console once we know the server's UUID
+  useEffect(() => {
+    if (!server?.uuid || !token) return
+
+    const wsUrl = `${process.env.NEXT_PUBLIC_WS_URL?.replace('http', 'ws') || 'ws://localhost:3000'}/ws`
+    const ws = new WebSocket(`${wsUrl}?token=${encodeURIComponent(token)}`)
+
+    ws.onopen = () => {
+      ws.send(JSON.stringify({ type: 'subscribe', server_id: server.uuid }))
+      terminal.current?.write('Connected to server console\r\n')
+      terminal.current?.write('Type a command...\r\n\r\n')
+    }
+
+    ws.onmessage = (event) => {
+      const data = JSON.parse(event.data)
+      if (data.type === 'console') {
+        terminal.current?.write(data.message)
+      } else if (data.type === 'error') {
+        terminal.current?.write(`\x1b[31m${data.error}\x1b[0m\r\n`)
+      }
+    }
+
+    wsRef.current = ws
+
+    return () => {
+      ws.close()
+    }
+  }, [server?.uuid, token])
+
   const fetchServer = async () => {
     try {
       const response = await api.get(`/servers/${serverId}`)
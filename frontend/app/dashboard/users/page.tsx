@@ -7,9 +7,10 @@ interface User {
   id: number
   email: string
   username: string
-  role: string
+  role?: { id: number; name: string }
   created_at: string
-  last_login?: string
+  last_login_at?: string | null
+  suspended_at?: string | null
 }
 
 export default function UsersPage() {
@@ -18,26 +19,17 @@ export default function UsersPage() {
   const [search, setSearch] = useState('')
 
   useEffect(() => {
-    fetchUsers()
-  }, [])
+    // Wait for the user to stop typing before searching
+    const timeout = setTimeout(() => fetchUsers(search), 300)
+    return () => clearTimeout(timeout)
+  }, [search])
 
-  const fetchUsers = async () => {
+  const fetchUsers = async (query: string) => {
     try {
-      // TODO: Implement users API endpoint
-      // const response = await api.get('/admin/users')
-      // setUsers(response.data)
-      
-      // Mock data for now
-      setUsers([
-        {
-          id: 1,
-          email: 'admin@example.com',
-          username: 'admin',
-          role: 'admin',
-          created_at: '2024-01-01',
-          last_login: '2024-01-15',
-        },
-      ])
+      const response = await api.get('/admin/users', {
+        params: { search: query || undefined, per_page: 100 },
+      })
+      setUsers(response.data.data)
     } catch (error) {
       console.error('Failed to fetch users:', error)
     } finally {
@@ -45,11 +37,6 @@ export default function UsersPage() {
     }
   }
 
-  const filteredUsers = users.filter(user =>
-    user.email.toLowerCase().includes(search.toLowerCase()) ||
-    user.username.toLowerCase().includes(search.toLowerCase())
-  )
-
   return (
     <div className="p-8">
       <div className="mb-8 flex items-center justify-between">
@@ -91,7 +78,7 @@ export default function UsersPage() {
               </tr>
             </thead>
             <tbody className="divide-y divide-primary-200">
-              {filteredUsers.map((user) => (
+              {users.map((user) => (
                 <tr key={user.id} className="hover:bg-primary-50 transition-colors">
                   <td className="px-6 py-4">
                     <div>
@@ -101,18 +88,23 @@ export default function UsersPage() {
                   </td>
                   <td className="px-6 py-4">
                     <span className={`px-2 py-1 text-xs font-medium rounded ${
-                      user.role === 'admin'
+                      user.role?.name === 'admin'
                         ? 'bg-purple-100 text-purple-700'
                         : 'bg-blue-100 text-blue-700'
                     }`}>
-                      {user.role}
+                      {user.role?.name}
                     </span>
+                    {user.suspended_at && (
+                      <span className="ml-2 px-2 py-1 text-xs font-medium rounded bg-red-100 text-red-700">
+                        suspended
+                      </span>
+                    )}
                   </td>
                   <td className="px-6 py-4 text-sm text-primary-600">
                     {new Date(user.created_at).toLocaleDateString()}
                   </td>
                   <td className="px-6 py-4 text-sm text-primary-600">
-                    {user.last_login ? new Date(user.last_login).toLocaleDateString() : 'Never'}
+                    {user.last_login_at ? new Date(user.last_login_at).toLocaleDateString() : 'Never'}
                   </td>
                   <td className="px-6 py-4 text-right">
                     <button className="text-accent hover:text-accent-dark font-medium text-sm">
@@ -1,4 +1,5 @@
 import axios from 'axios'
+import { useAuthStore } from '@/store/auth'
 
 const api = axios.create({
   baseURL: process.env.NEXT_PUBLIC_API_URL || 'http://localhost:3000/api/v1',
@@ -28,12 +29,44 @@ api.interceptors.request.use(
   }
 )
 
+// Refresh tokens are single use, so concurrent 401s must share one refresh
+let refreshing: Promise<string | null> | null = null
+
+const refreshAccessToken = async (): Promise<string | null> => {
+  const { refreshToken, user, setAuth, logout } = useAuthStore.getState()
+  if (!refreshToken || !user) return null
+
+  try {
+    const response = await axios.post(`${api.defaults.baseURL}/auth/refresh`, {
+      refresh_token: refreshToken,
+    })
+    setAuth(response.data.token, response.data.refresh_token, user)
+    return response.data.token
+  } catch (e) {
+    logout()
+    return null
+  }
+}
+
 // Response interceptor for error handling
 api.interceptors.response.use(
   (response) => response,
   async (error) => {
+    const original = error.config
     if (error.response?.status === 401) {
-      // Handle token refresh or redirect to login
+      // Retry once with a fresh access token before giving up on the session
+      if (original && !original._retried) {
+        original._retried = true
+        refreshing = refreshing || refreshAccessToken().finally(() => {
+          refreshing = null
+        })
+
+        const token = await refreshing
+        if (token) {
+          original.headers.Authorization = `Bearer ${token}`
+          return api(original)
+        }
+      }
       window.location.href = '/auth/login'
     }
     return Promise.reject(error)
@@ -6,6 +6,7 @@ interface User {
   email: string
   username: string
   role_id: number
+  email_verified_at?: string | null
 }
 
 interface AuthState {